@@ -0,0 +1,163 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/dhrapson/sched-load/iaas"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultProfileName is the profile used when none is chosen, by flag, environment or the file's default_profile
+const DefaultProfileName = "default"
+
+// Config holds named profiles, so that a source system is configured once & then run unattended, e.g. from cron,
+// with just --profile
+type Config struct {
+	DefaultProfile string             `yaml:"default_profile,omitempty"`
+	Profiles       map[string]Profile `yaml:"profiles"`
+}
+
+// Profile holds the settings of a profile, each standing in for the global flag of the same name
+type Profile struct {
+	Backend            string `yaml:"backend,omitempty" json:"backend,omitempty"`
+	Region             string `yaml:"region,omitempty" json:"region,omitempty"`
+	Endpoint           string `yaml:"endpoint,omitempty" json:"endpoint,omitempty"`
+	Root               string `yaml:"root,omitempty" json:"root,omitempty"`
+	Integrator         string `yaml:"integrator,omitempty" json:"integrator,omitempty"`
+	Client             string `yaml:"client,omitempty" json:"client,omitempty"`
+	Credentials        string `yaml:"credentials,omitempty" json:"credentials,omitempty"`
+	CredentialsProfile string `yaml:"credentials_profile,omitempty" json:"credentials_profile,omitempty"`
+	Proxy              string `yaml:"proxy,omitempty" json:"proxy,omitempty"`
+	SSE                string `yaml:"sse,omitempty" json:"sse,omitempty"`
+	SSEKMSKey          string `yaml:"sse_kms_key,omitempty" json:"sse_kms_key,omitempty"`
+}
+
+// DefaultPath is ~/.sched-load/config.yaml, or empty when there is no home directory
+func DefaultPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".sched-load", "config.yaml")
+}
+
+// Load reads the config file, with a missing file giving an empty config. Unknown settings are refused, so that
+// a misspelt setting is not silently ignored.
+func Load(path string) (config Config, err error) {
+	if path == "" {
+		return
+	}
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Config{}, nil
+	}
+	if err != nil {
+		return
+	}
+	if err = yaml.UnmarshalStrict(contents, &config); err != nil {
+		return Config{}, errors.New("Invalid config file " + path + ": " + err.Error())
+	}
+	return
+}
+
+// Save writes the config file, creating its directory if need be
+func (config Config) Save(path string) (err error) {
+	if path == "" {
+		return errors.New("You must specify the config file path")
+	}
+	contents, err := yaml.Marshal(config)
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	return ioutil.WriteFile(path, contents, 0600)
+}
+
+// ProfileName gives the name of the profile to use: the one chosen, else the file's default, else "default"
+func (config Config) ProfileName(name string) string {
+	if name != "" {
+		return name
+	}
+	if config.DefaultProfile != "" {
+		return config.DefaultProfile
+	}
+	return DefaultProfileName
+}
+
+// Profile gives the named profile, as chosen by ProfileName. A profile chosen by name must exist, whereas no
+// "default" profile just gives empty settings.
+func (config Config) Profile(name string) (profile Profile, err error) {
+	profileName := config.ProfileName(name)
+	profile, found := config.Profiles[profileName]
+	if !found && (name != "" || config.DefaultProfile != "") {
+		err = errors.New("Unknown profile " + profileName)
+	}
+	return
+}
+
+// SetProfile adds or replaces the named profile
+func (config *Config) SetProfile(name string, profile Profile) {
+	if config.Profiles == nil {
+		config.Profiles = make(map[string]Profile)
+	}
+	config.Profiles[name] = profile
+}
+
+// ProfileNames gives the names of the profiles in alphabetical order
+func (config Config) ProfileNames() (names []string) {
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// Validate checks every profile, & that the default profile exists
+func (config Config) Validate() (err error) {
+	if config.DefaultProfile != "" {
+		if _, found := config.Profiles[config.DefaultProfile]; !found {
+			return errors.New("Unknown default profile " + config.DefaultProfile)
+		}
+	}
+
+	for _, name := range config.ProfileNames() {
+		if err = config.Profiles[name].Validate(); err != nil {
+			return errors.New("Invalid profile " + name + ": " + err.Error())
+		}
+	}
+	return
+}
+
+// Validate checks the profile's settings, with unset settings left to the flags' defaults
+func (profile Profile) Validate() (err error) {
+	switch profile.Backend {
+	case "", "aws":
+	case "filesystem":
+		if profile.Root == "" {
+			return errors.New("You must specify a root directory for the filesystem backend")
+		}
+	default:
+		return errors.New("Unknown backend " + profile.Backend + ", expected aws or filesystem")
+	}
+	if profile.Endpoint != "" {
+		if endpoint, parseErr := url.Parse(profile.Endpoint); parseErr != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+			return errors.New("Invalid endpoint URL " + profile.Endpoint + ", expected e.g. https://minio.example.com:9000")
+		}
+	}
+	if profile.Proxy != "" {
+		if _, err = iaas.ParseProxy(profile.Proxy); err != nil {
+			return
+		}
+	}
+	if err = iaas.ValidateServerSideEncryption(profile.SSE, profile.SSEKMSKey); err != nil {
+		return
+	}
+	return iaas.ValidateCredentials(profile.Credentials, profile.CredentialsProfile)
+}
@@ -0,0 +1,87 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/dhrapson/sched-load/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("The config file", func() {
+
+	var (
+		dir  string
+		path string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "sched-load-config")
+		Ω(err).ShouldNot(HaveOccurred())
+		path = filepath.Join(dir, ".sched-load", "config.yaml")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("round-trips named profiles", func() {
+		config, err := Load(path)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(config.Profiles).Should(BeEmpty())
+
+		production := Profile{Region: "eu-west-1", Client: "myclient", Credentials: "shared", CredentialsProfile: "sched-load", Proxy: "http://proxy.example.com:3128"}
+		local := Profile{Backend: "filesystem", Root: "/srv/sched-load", Integrator: "myintegrator"}
+		config.SetProfile("production", production)
+		config.SetProfile("local", local)
+		config.DefaultProfile = "production"
+		Ω(config.Validate()).Should(Succeed())
+		Ω(config.Save(path)).Should(Succeed())
+
+		info, err := os.Stat(path)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(info.Mode().Perm()).Should(Equal(os.FileMode(0600)))
+
+		config, err = Load(path)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(config.Profile("")).Should(Equal(production))
+		Ω(config.Profile("local")).Should(Equal(local))
+		_, err = config.Profile("staging")
+		Ω(err).Should(MatchError("Unknown profile staging"))
+	})
+
+	It("gives empty settings when there is no default profile", func() {
+		config, err := Load(path)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(config.ProfileName("")).Should(Equal(DefaultProfileName))
+		Ω(config.Profile("")).Should(Equal(Profile{}))
+	})
+
+	It("refuses unknown settings", func() {
+		Ω(os.MkdirAll(filepath.Dir(path), 0700)).Should(Succeed())
+		Ω(ioutil.WriteFile(path, []byte("profiles:\n  default:\n    regoin: eu-west-1\n"), 0600)).Should(Succeed())
+		_, err := Load(path)
+		Ω(err).Should(HaveOccurred())
+		Ω(err.Error()).Should(ContainSubstring("Invalid config file " + path))
+	})
+
+	It("validates each profile", func() {
+		var config Config
+		config.SetProfile("local", Profile{Backend: "filesystem"})
+		Ω(config.Validate()).Should(MatchError("Invalid profile local: You must specify a root directory for the filesystem backend"))
+
+		Ω(Profile{Backend: "gcs"}.Validate()).Should(MatchError("Unknown backend gcs, expected aws or filesystem"))
+		Ω(Profile{Credentials: "instance"}.Validate()).Should(MatchError("Unknown credentials source instance, expected env or shared"))
+		Ω(Profile{Credentials: "env", CredentialsProfile: "other"}.Validate()).Should(MatchError("A credentials profile can only be given for shared credentials"))
+		Ω(Profile{Proxy: "proxy:3128"}.Validate()).Should(HaveOccurred())
+		Ω(Profile{Endpoint: "minio"}.Validate()).Should(HaveOccurred())
+		Ω(Profile{SSE: "aws:kms", SSEKMSKey: "alias/myclient"}.Validate()).Should(Succeed())
+		Ω(Profile{SSE: "AES256", SSEKMSKey: "alias/myclient"}.Validate()).Should(MatchError("A KMS key can only be given for aws:kms server-side encryption"))
+
+		config = Config{DefaultProfile: "production"}
+		Ω(config.Validate()).Should(MatchError("Unknown default profile production"))
+	})
+})
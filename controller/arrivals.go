@@ -0,0 +1,166 @@
+package controller
+
+import (
+	"errors"
+	"time"
+)
+
+// arrivalHorizon bounds the search for arrivals, long enough for any satisfiable schedule, e.g. a cron on the 29th of February
+const arrivalHorizon = 5 * 366 * 24 * time.Hour
+
+// Arrival is one expected delivery of a schedule: the window the file should arrive within, in UTC,
+// & the deadline after which it is reported as missing
+type Arrival struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Deadline time.Time `json:"deadline"`
+}
+
+func (controller Controller) NextArrivals(after time.Time, count int) (arrivals []Arrival, err error) {
+	schedule, err := controller.GetSchedule()
+	if err != nil {
+		return
+	}
+	return schedule.NextArrivals(after, count)
+}
+
+// NextArrivals gives the next count arrivals whose window has not ended by the given time, skipping any paused.
+// Windows are in the schedule's local time, so they move in UTC across daylight saving transitions.
+func (schedule Schedule) NextArrivals(after time.Time, count int) (arrivals []Arrival, err error) {
+	if !schedule.IsSet() {
+		err = errors.New("No schedule is set")
+		return
+	}
+	if count < 1 {
+		err = errors.New("The count must be at least 1")
+		return
+	}
+	err = schedule.eachArrival(after, func(arrival Arrival) bool {
+		arrivals = append(arrivals, arrival)
+		return len(arrivals) < count
+	})
+	return
+}
+
+// eachArrival visits each arrival in order, starting with the first whose window ends after from, until visit returns false
+func (schedule Schedule) eachArrival(from time.Time, visit func(Arrival) bool) (err error) {
+	if err = schedule.Validate(); err != nil {
+		return
+	}
+	location, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return
+	}
+	limit := from.Add(arrivalHorizon)
+
+	if schedule.Interval == CronInterval {
+		spec, parseErr := parseCron(schedule.Cron)
+		if parseErr != nil {
+			return parseErr
+		}
+		length := schedule.Window.length()
+		var previous time.Time
+		for start := spec.next(from.Add(-length - time.Minute).In(location)); !start.IsZero() && start.Before(limit); start = spec.next(start) {
+			// when clocks go back the same wall clock time happens twice, cron only runs once
+			if !previous.IsZero() && sameWallClock(previous, start) {
+				continue
+			}
+			previous = start
+			if done, skip := schedule.paused(start); done {
+				return
+			} else if skip {
+				continue
+			}
+			arrival := schedule.newArrival(start, start.Add(length))
+			if arrival.End.After(from) && !visit(arrival) {
+				return
+			}
+		}
+		return
+	}
+
+	local := from.In(location)
+	// start a day early, as the previous day's window may span midnight
+	day := time.Date(local.Year(), local.Month(), local.Day()-1, 0, 0, 0, 0, location)
+	for ; day.Before(limit); day = time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, location) {
+		if !schedule.isArrivalDay(day) {
+			continue
+		}
+		arrival := schedule.arrivalOn(day)
+		if done, skip := schedule.paused(arrival.Start); done {
+			return
+		} else if skip {
+			continue
+		}
+		if arrival.End.After(from) && !visit(arrival) {
+			return
+		}
+	}
+	return
+}
+
+// paused tells whether an arrival starting at the given time is skipped, & whether every later arrival is too
+func (schedule Schedule) paused(start time.Time) (done bool, skip bool) {
+	if !schedule.Pause.Covers(start) {
+		return false, false
+	}
+	return schedule.Pause.Until.IsZero(), true
+}
+
+func (schedule Schedule) isArrivalDay(day time.Time) bool {
+	switch schedule.Interval {
+	case WeeklyInterval:
+		return day.Weekday() == schedule.Weekday
+	case MonthlyInterval:
+		lastDay := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, day.Location()).Day()
+		if schedule.DayOfMonth > lastDay {
+			return day.Day() == lastDay
+		}
+		return day.Day() == schedule.DayOfMonth
+	default:
+		return true
+	}
+}
+
+// arrivalOn builds the arrival starting on the given local day, using wall clock times so that
+// a 02:00-04:00 window stays at 02:00-04:00 local time whatever the UTC offset that day
+func (schedule Schedule) arrivalOn(day time.Time) Arrival {
+	if !schedule.Window.IsSet() {
+		return schedule.newArrival(day, time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, day.Location()))
+	}
+	start := atTimeOfDay(day, schedule.Window.Start)
+	endDay := day
+	if schedule.Window.End <= schedule.Window.Start {
+		endDay = time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, day.Location())
+	}
+	return schedule.newArrival(start, atTimeOfDay(endDay, schedule.Window.End))
+}
+
+func (schedule Schedule) newArrival(start time.Time, end time.Time) Arrival {
+	return Arrival{
+		Start:    start.UTC(),
+		End:      end.UTC(),
+		Deadline: end.Add(schedule.GracePeriod).UTC(),
+	}
+}
+
+// length of the window, which is zero when not set
+func (window ArrivalWindow) length() time.Duration {
+	if !window.IsSet() {
+		return 0
+	}
+	if window.End <= window.Start {
+		return window.End + 24*time.Hour - window.Start
+	}
+	return window.End - window.Start
+}
+
+func atTimeOfDay(day time.Time, offset time.Duration) time.Time {
+	hours := int(offset / time.Hour)
+	minutes := int((offset % time.Hour) / time.Minute)
+	return time.Date(day.Year(), day.Month(), day.Day(), hours, minutes, 0, 0, day.Location())
+}
+
+func sameWallClock(a time.Time, b time.Time) bool {
+	return a.Year() == b.Year() && a.YearDay() == b.YearDay() && a.Hour() == b.Hour() && a.Minute() == b.Minute()
+}
@@ -0,0 +1,160 @@
+package controller_test
+
+import (
+	"time"
+
+	. "github.com/dhrapson/sched-load/controller"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Expected arrivals", func() {
+
+	var (
+		schedule Schedule
+		arrivals []Arrival
+		err      error
+	)
+
+	utc := func(value string) time.Time {
+		t, parseErr := time.Parse(time.RFC3339, value)
+		Ω(parseErr).ShouldNot(HaveOccurred())
+		return t
+	}
+
+	starts := func(arrivals []Arrival) (values []string) {
+		for _, arrival := range arrivals {
+			values = append(values, arrival.Start.Format(time.RFC3339))
+		}
+		return
+	}
+
+	Context("with a daily window in Europe/London", func() {
+		BeforeEach(func() {
+			schedule = Schedule{Interval: DailyInterval, Timezone: "Europe/London", Window: ArrivalWindow{Start: 2 * time.Hour, End: 4 * time.Hour}}
+		})
+
+		It("moves an hour earlier in UTC when the clocks go forward", func() {
+			arrivals, err = schedule.NextArrivals(utc("2026-03-27T12:00:00Z"), 3)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(arrivals).Should(Equal([]Arrival{
+				{Start: utc("2026-03-28T02:00:00Z"), End: utc("2026-03-28T04:00:00Z"), Deadline: utc("2026-03-28T04:00:00Z")},
+				{Start: utc("2026-03-29T01:00:00Z"), End: utc("2026-03-29T03:00:00Z"), Deadline: utc("2026-03-29T03:00:00Z")},
+				{Start: utc("2026-03-30T01:00:00Z"), End: utc("2026-03-30T03:00:00Z"), Deadline: utc("2026-03-30T03:00:00Z")},
+			}))
+		})
+
+		It("moves an hour later in UTC when the clocks go back", func() {
+			arrivals, err = schedule.NextArrivals(utc("2026-10-23T12:00:00Z"), 3)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(starts(arrivals)).Should(Equal([]string{"2026-10-24T01:00:00Z", "2026-10-25T02:00:00Z", "2026-10-26T02:00:00Z"}))
+		})
+
+		It("includes a window that is still open", func() {
+			arrivals, err = schedule.NextArrivals(utc("2026-01-10T03:00:00Z"), 1)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(starts(arrivals)).Should(Equal([]string{"2026-01-10T02:00:00Z"}))
+		})
+
+		It("adds the grace period to the deadline", func() {
+			schedule.GracePeriod = 90 * time.Minute
+			arrivals, err = schedule.NextArrivals(utc("2026-01-10T12:00:00Z"), 1)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(arrivals[0].Deadline).Should(Equal(utc("2026-01-11T05:30:00Z")))
+		})
+	})
+
+	It("rejects a count of less than one", func() {
+		schedule = Schedule{Interval: DailyInterval, Timezone: "UTC"}
+		_, err = schedule.NextArrivals(utc("2026-06-02T03:00:00Z"), 0)
+		Ω(err).Should(MatchError("The count must be at least 1"))
+		_, err = schedule.NextArrivals(utc("2026-06-02T03:00:00Z"), -1)
+		Ω(err).Should(MatchError("The count must be at least 1"))
+	})
+
+	It("spans midnight when the window ends before it starts", func() {
+		schedule = Schedule{Interval: DailyInterval, Timezone: "America/New_York", Window: ArrivalWindow{Start: 22 * time.Hour, End: 2 * time.Hour}}
+		arrivals, err = schedule.NextArrivals(utc("2026-06-02T03:00:00Z"), 1)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(arrivals).Should(Equal([]Arrival{
+			{Start: utc("2026-06-02T02:00:00Z"), End: utc("2026-06-02T06:00:00Z"), Deadline: utc("2026-06-02T06:00:00Z")},
+		}))
+	})
+
+	It("expects a whole local day when no window is set", func() {
+		schedule = Schedule{Interval: DailyInterval, Timezone: "Europe/London"}
+		arrivals, err = schedule.NextArrivals(utc("2026-03-28T12:00:00Z"), 2)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(arrivals[1].Start).Should(Equal(utc("2026-03-29T00:00:00Z")))
+		Ω(arrivals[1].End.Sub(arrivals[1].Start)).Should(Equal(23 * time.Hour))
+	})
+
+	It("finds weekly arrivals on the given weekday", func() {
+		schedule = Schedule{Interval: WeeklyInterval, Weekday: time.Monday, Timezone: "UTC"}
+		arrivals, err = schedule.NextArrivals(utc("2026-10-17T12:00:00Z"), 2)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(starts(arrivals)).Should(Equal([]string{"2026-10-19T00:00:00Z", "2026-10-26T00:00:00Z"}))
+	})
+
+	It("uses the last day of shorter months for monthly arrivals", func() {
+		schedule = Schedule{Interval: MonthlyInterval, DayOfMonth: 31, Timezone: "UTC"}
+		arrivals, err = schedule.NextArrivals(utc("2026-01-01T00:00:00Z"), 4)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(starts(arrivals)).Should(Equal([]string{"2026-01-31T00:00:00Z", "2026-02-28T00:00:00Z", "2026-03-31T00:00:00Z", "2026-04-30T00:00:00Z"}))
+	})
+
+	Context("with a cron schedule", func() {
+		It("finds weekday arrivals in the schedule's timezone", func() {
+			schedule = Schedule{Interval: CronInterval, Cron: "30 6 * * mon-fri", Timezone: "America/New_York", Window: ArrivalWindow{Start: 0, End: time.Hour}}
+			arrivals, err = schedule.NextArrivals(utc("2026-10-30T12:00:00Z"), 3)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(starts(arrivals)).Should(Equal([]string{"2026-11-02T11:30:00Z", "2026-11-03T11:30:00Z", "2026-11-04T11:30:00Z"}))
+			Ω(arrivals[0].End).Should(Equal(utc("2026-11-02T12:30:00Z")))
+		})
+
+		It("only expects one arrival when the clocks go back", func() {
+			schedule = Schedule{Interval: CronInterval, Cron: "30 1 * * *", Timezone: "Europe/London"}
+			arrivals, err = schedule.NextArrivals(utc("2026-10-24T12:00:00Z"), 2)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(starts(arrivals)).Should(Equal([]string{"2026-10-25T00:30:00Z", "2026-10-26T01:30:00Z"}))
+		})
+
+		It("finds rare arrivals", func() {
+			schedule = Schedule{Interval: CronInterval, Cron: "0 0 29 2 *", Timezone: "UTC"}
+			arrivals, err = schedule.NextArrivals(utc("2026-10-17T00:00:00Z"), 1)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(starts(arrivals)).Should(Equal([]string{"2028-02-29T00:00:00Z"}))
+		})
+	})
+
+	Context("with a paused schedule", func() {
+		BeforeEach(func() {
+			schedule = Schedule{Interval: DailyInterval, Timezone: "UTC"}
+		})
+
+		It("skips arrivals until the pause ends", func() {
+			schedule.Pause = Pause{From: utc("2026-10-17T00:00:00Z"), Until: utc("2026-10-19T12:00:00Z")}
+			arrivals, err = schedule.NextArrivals(utc("2026-10-16T12:00:00Z"), 3)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(starts(arrivals)).Should(Equal([]string{"2026-10-16T00:00:00Z", "2026-10-20T00:00:00Z", "2026-10-21T00:00:00Z"}))
+		})
+
+		It("expects nothing until resumed", func() {
+			schedule.Pause = Pause{From: utc("2026-10-17T00:00:00Z")}
+			arrivals, err = schedule.NextArrivals(utc("2026-10-16T12:00:00Z"), 3)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(starts(arrivals)).Should(Equal([]string{"2026-10-16T00:00:00Z"}))
+		})
+	})
+
+	It("throws an error without a schedule", func() {
+		_, err = Schedule{}.NextArrivals(utc("2026-10-17T00:00:00Z"), 1)
+		Ω(err).Should(MatchError("No schedule is set"))
+	})
+
+	It("throws an error for an unknown timezone", func() {
+		schedule = Schedule{Interval: DailyInterval, Timezone: "Mars/Olympus_Mons"}
+		_, err = schedule.NextArrivals(utc("2026-10-17T00:00:00Z"), 1)
+		Ω(err).Should(HaveOccurred())
+	})
+})
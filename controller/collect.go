@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/dhrapson/sched-load/iaas"
+)
+
+// Folders of a client's upload area. Data files arrive in INPUT/, & once collected can be moved to PROCESSED/ or
+// ARCHIVE/, so that list-uploaded only shows the files still to be collected.
+const (
+	InputFolder     = "INPUT/"
+	ProcessedFolder = "PROCESSED/"
+	ArchiveFolder   = "ARCHIVE/"
+)
+
+// ParseFolder gives the folder that collected files are moved to, from its name, processed or archive.
+// No name means the files are left in INPUT/.
+func ParseFolder(name string) (folder string, err error) {
+	switch strings.ToLower(name) {
+	case "":
+		return "", nil
+	case "processed":
+		return ProcessedFolder, nil
+	case "archive":
+		return ArchiveFolder, nil
+	}
+	return "", errors.New("Unknown folder " + name + ", expected processed or archive")
+}
+
+// CollectResult is the outcome of collecting one of the data files in INPUT/. A file that was Skipped, as it was
+// already downloaded, is left in INPUT/.
+type CollectResult struct {
+	FileName  string
+	LocalPath string
+	MovedTo   string
+	Skipped   bool
+	Err       error
+}
+
+// CollectDataFiles downloads every data file in INPUT/, then moves each one downloaded to the given folder, if any.
+// It carries on past failures, & a file that could not be downloaded, or was skipped, is left in INPUT/.
+func (controller Controller) CollectDataFiles(localDir string, folder string) (results []CollectResult, err error) {
+
+	if err = validateFolder(folder); err != nil {
+		return
+	}
+	fileNames, err := controller.ListDataFiles()
+	if err != nil {
+		return
+	}
+
+	for _, fileName := range fileNames {
+		result := CollectResult{FileName: fileName}
+		result.LocalPath, result.Err = controller.DownloadDataFile(fileName, localDir)
+		if result.Err == iaas.ErrSkipped {
+			result.Skipped, result.Err = true, nil
+		} else if result.Err == nil && folder != "" {
+			result.MovedTo, result.Err = controller.MoveDataFile(fileName, folder)
+		}
+		results = append(results, result)
+	}
+	return
+}
+
+// MoveDataFile moves a data file from INPUT/ to the given folder, keeping its path beneath it
+func (controller Controller) MoveDataFile(fileName string, folder string) (movedTo string, err error) {
+
+	if err = validateFolder(folder); err != nil {
+		return
+	}
+	if folder == "" {
+		return "", errors.New("You must specify the folder to move the file to")
+	}
+	if !strings.HasPrefix(fileName, InputFolder) || len(fileName) <= len(InputFolder) {
+		return "", errors.New("Only files in " + InputFolder + " can be moved, not " + fileName)
+	}
+
+	target := folder + strings.TrimPrefix(fileName, InputFolder)
+	if err = controller.Client.MoveFile(fileName, target); err != nil {
+		return
+	}
+	movedTo = target
+	return
+}
+
+// ListProcessedDataFiles lists the data files that have been moved to PROCESSED/ or ARCHIVE/ once collected
+func (controller Controller) ListProcessedDataFiles() (result []string, err error) {
+
+	var fileNames []string
+	if fileNames, err = controller.Client.ListFiles(); err != nil {
+		return
+	}
+
+	for _, fileName := range fileNames {
+		for _, folder := range []string{ProcessedFolder, ArchiveFolder} {
+			if strings.HasPrefix(fileName, folder) && len(fileName) > len(folder) {
+				result = append(result, fileName)
+			}
+		}
+	}
+	return
+}
+
+func validateFolder(folder string) error {
+	switch folder {
+	case "", ProcessedFolder, ArchiveFolder:
+		return nil
+	}
+	return errors.New("Unknown folder " + folder + ", expected " + ProcessedFolder + " or " + ArchiveFolder)
+}
@@ -2,10 +2,10 @@ package controller
 
 import (
 	"errors"
-	"io/ioutil"
+	"fmt"
 	"os"
 	"path"
-	"strings"
+	"time"
 
 	"github.com/dhrapson/sched-load/iaas"
 )
@@ -23,8 +23,15 @@ func (controller Controller) Status() (details iaas.IaaSAccountDetails, err erro
 		_, err = controller.Client.ListFiles()
 		if err != nil {
 			err = errors.New("Unable to connect to upload area: " + err.Error())
+			return
 		}
 	}
+	encryption, err := controller.Client.EncryptionStatus()
+	if err != nil {
+		err = errors.New("Invalid encryption: " + err.Error())
+		return
+	}
+	details["Encryption"] = encryption
 	return
 }
 
@@ -50,18 +57,47 @@ func (controller Controller) DeleteClientUser(force bool) (wasPreExisting bool,
 }
 func (controller Controller) ListDataFiles() (result []string, err error) {
 
-	var fileNames []string
-	if fileNames, err = controller.Client.ListFiles(); err != nil {
+	_, err = controller.WalkDataFiles(ListOptions{}, func(file iaas.FileInfo) error {
+		result = append(result, file.Name)
+		return nil
+	})
+	return
+}
+
+// ListOptions narrow a listing of data files to those whose paths within INPUT/ start with the Prefix,
+// & to the first Limit of them, if set. WithDetails looks up each file's checksum & encryption.
+type ListOptions struct {
+	Prefix      string
+	Limit       int
+	WithDetails bool
+}
+
+// WalkDataFiles calls walkFn for each data file in INPUT/ in turn, without listing them all up front,
+// & tells whether any files were left out by the Limit
+func (controller Controller) WalkDataFiles(options ListOptions, walkFn iaas.WalkFunc) (truncated bool, err error) {
+
+	if options.Limit < 0 {
+		err = errors.New("The limit must not be negative")
 		return
 	}
 
-	for _, fileName := range fileNames {
-		// if its a key within INPUT/, and not INPUT/ itself
-		if strings.Index(fileName, "INPUT/") == 0 && len(fileName) > 6 {
-			result = append(result, fileName)
+	count := 0
+	err = controller.Client.WalkFiles(InputFolder+options.Prefix, func(file iaas.FileInfo) (walkErr error) {
+		if file.Name == InputFolder {
+			return nil
 		}
-	}
-
+		if options.Limit > 0 && count == options.Limit {
+			truncated = true
+			return iaas.StopWalk
+		}
+		count++
+		if options.WithDetails {
+			if file, walkErr = controller.Client.StatFile(file.Name); walkErr != nil {
+				return
+			}
+		}
+		return walkFn(file)
+	})
 	return
 }
 
@@ -80,25 +116,63 @@ func (controller Controller) DisableImmediateDataFileCollection() (removedExisti
 	return
 }
 
+// AbortIncompleteUploads cleans up uploads started before the given time that never completed, e.g. as the process was killed
+func (controller Controller) AbortIncompleteUploads(initiatedBefore time.Time) (names []string, err error) {
+	names, err = controller.Client.AbortIncompleteUploads(initiatedBefore)
+	return
+}
+
 func (controller Controller) UploadDataFile(filePath string) (result string, err error) {
+	return controller.UploadDataFileAs(filePath, path.Base(filePath))
+}
+
+// DownloadDataFile downloads an uploaded data file, given by its name as listed, to a local directory.
+// A download skipped as the local file already exists gives iaas.ErrSkipped, with the existing file's path.
+func (controller Controller) DownloadDataFile(fileName string, localDir string) (localPath string, err error) {
+	if fileName == "" {
+		err = errors.New("You must specify the file to download")
+		return
+	}
+	localPath, err = controller.Client.GetFile(fileName, localDir)
+	return
+}
+
+// UploadDataFileAs uploads a data file to the given slash-separated path under INPUT/, preserving sub-directories
+func (controller Controller) UploadDataFileAs(filePath string, relativePath string) (result string, err error) {
 
 	result = "error"
-	targetFile := "INPUT/" + path.Base(filePath)
-	var fileName string
-	if fileName, err = controller.Client.UploadFile(filePath, targetFile); err != nil {
+	targetFile := "INPUT/" + relativePath
+
+	localFile, err := os.Stat(filePath)
+	if err != nil {
 		return
 	}
 
-	var fileNames []string
-	if fileNames, err = controller.Client.ListFiles(); err != nil {
+	var fileName, checksum string
+	if fileName, checksum, err = controller.Client.UploadFile(filePath, targetFile); err != nil {
 		return
 	}
 
-	if arrayContains(fileNames, fileName) {
-		result = fileName
-	} else {
-		err = errors.New("Unable to find uploaded file" + fileName)
+	// confirm the upload by its size & the checksum stored with it, as hashed while uploading, not just by its name
+	var uploaded iaas.FileInfo
+	if uploaded, err = controller.Client.StatFile(fileName); err != nil {
+		err = errors.New("Unable to find uploaded file " + fileName + ": " + err.Error())
+		return
+	}
+	uploadedSize := uploaded.Size
+	if uploaded.Compression != "" || uploaded.EncryptionKeyId != "" {
+		uploadedSize = uploaded.UncompressedSize
 	}
+	if uploadedSize != localFile.Size() {
+		err = fmt.Errorf("Uploaded file %s is %d bytes, expected %d", fileName, uploadedSize, localFile.Size())
+		return
+	}
+	// the checksum of an encrypted file is sealed with its data key, so is only verified on download
+	if uploaded.EncryptionKeyId == "" && uploaded.Checksum != checksum {
+		err = errors.New("Uploaded file " + fileName + " has checksum '" + uploaded.Checksum + "', expected '" + checksum + "'")
+		return
+	}
+	result = fileName
 	return
 }
 
@@ -107,46 +181,54 @@ func (controller Controller) DeleteDataFile(filePath string) (result bool, err e
 	return controller.Client.DeleteFile(targetFile)
 }
 
-func (controller Controller) RemoveSchedule() (previouslySet bool, err error) {
-	return controller.Client.DeleteFile("DAILY_SCHEDULE")
+// DataFileExists checks for a data file in INPUT/, e.g. to report what a dry run would delete
+func (controller Controller) DataFileExists(filePath string) (exists bool, err error) {
+	return controller.remoteFileExists("INPUT/" + filePath)
 }
 
-func (controller Controller) SetSchedule(interval string) (result bool, err error) {
-	result = false
-	targetFile := interval + "_SCHEDULE"
-	var tempFile *os.File
-	tempFile, err = ioutil.TempFile("", "set-schedule")
-	defer tempFile.Close()
-	if err != nil {
+// DeleteOptions choose the data files in INPUT/ to delete: those whose paths within it start with the Prefix,
+// & were last modified at least OlderThan ago. With DryRun the files are only listed.
+type DeleteOptions struct {
+	Prefix    string
+	OlderThan time.Duration
+	DryRun    bool
+}
+
+// DeleteDataFiles deletes the chosen data files in batches, giving those deleted, or those that would be with DryRun.
+// On error the files deleted so far are still given.
+func (controller Controller) DeleteDataFiles(options DeleteOptions, now time.Time) (files []iaas.FileInfo, err error) {
+
+	if options.OlderThan < 0 {
+		err = errors.New("The age of files to delete must not be negative")
 		return
 	}
 
-	var fileName string
-	fileName, err = controller.Client.UploadFile(tempFile.Name(), targetFile)
-	result = (fileName == targetFile)
-	return
-}
+	cutoff := now.Add(-options.OlderThan)
+	var chosen []iaas.FileInfo
+	_, err = controller.WalkDataFiles(ListOptions{Prefix: options.Prefix}, func(file iaas.FileInfo) error {
+		if !file.LastModified.After(cutoff) {
+			chosen = append(chosen, file)
+		}
+		return nil
+	})
+	if err != nil || options.DryRun || len(chosen) == 0 {
+		return chosen, err
+	}
 
-func (controller Controller) GetSchedule() (result string, err error) {
-	result = "ERROR"
-	fileName := "DAILY_SCHEDULE"
-	var fileNames []string
-	if fileNames, err = controller.Client.ListFiles(); err != nil {
-		return
+	var names []string
+	for _, file := range chosen {
+		names = append(names, file.Name)
 	}
+	deleted, err := controller.Client.DeleteFiles(names)
 
-	if arrayContains(fileNames, fileName) {
-		result = "DAILY"
-	} else {
-		result = "NONE"
+	wasDeleted := map[string]bool{}
+	for _, name := range deleted {
+		wasDeleted[name] = true
 	}
-	return
-}
-func arrayContains(haystack []string, needle string) bool {
-	for _, hay := range haystack {
-		if needle == hay {
-			return true
+	for _, file := range chosen {
+		if wasDeleted[file.Name] {
+			files = append(files, file)
 		}
 	}
-	return false
+	return
 }
@@ -0,0 +1,438 @@
+package controller_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "github.com/dhrapson/sched-load/controller"
+	"github.com/dhrapson/sched-load/iaas"
+	"github.com/dhrapson/sched-load/iaas/memory"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("The controller against an in-memory IaaS", func() {
+
+	var (
+		store  *memory.Store
+		ctrler Controller
+		files  []string
+		status bool
+	)
+
+	BeforeEach(func() {
+		store = memory.NewStore()
+		ctrler = Controller{Client: memory.Client{Store: store, IntegratorId: "myintegrator", ClientId: "myclient"}}
+	})
+
+	It("lists an uploaded data file until it is deleted", func() {
+		fileName, err := ctrler.UploadDataFile("../iaas/fixtures/test-file.csv")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(fileName).Should(Equal("INPUT/test-file.csv"))
+
+		files, err = ctrler.ListDataFiles()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(files).Should(Equal([]string{"INPUT/test-file.csv"}))
+
+		status, err = ctrler.DataFileExists("test-file.csv")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(status).Should(BeTrue())
+
+		status, err = ctrler.DeleteDataFile("test-file.csv")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(status).Should(BeTrue())
+
+		status, err = ctrler.DataFileExists("test-file.csv")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(status).Should(BeFalse())
+
+		files, err = ctrler.ListDataFiles()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(files).Should(BeEmpty())
+	})
+
+	It("round-trips the schedule", func() {
+		schedule, err := ctrler.GetSchedule()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(schedule.String()).Should(Equal("NONE"))
+
+		_, err = ctrler.SetSchedule(Schedule{Interval: DailyInterval})
+		Ω(err).ShouldNot(HaveOccurred())
+		schedule, err = ctrler.GetSchedule()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(schedule.String()).Should(Equal("DAILY"))
+
+		status, err = ctrler.RemoveSchedule()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(status).Should(BeTrue())
+		schedule, err = ctrler.GetSchedule()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(schedule.String()).Should(Equal("NONE"))
+	})
+
+	It("replaces the schedule with one of a different interval", func() {
+		weekly, err := ParseSchedule("WEEKLY fri")
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = ctrler.SetSchedule(weekly)
+		Ω(err).ShouldNot(HaveOccurred())
+		schedule, err := ctrler.GetSchedule()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(schedule.String()).Should(Equal("WEEKLY fri"))
+
+		cron, err := ParseSchedule("CRON 0 6 * * 1-5")
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = ctrler.SetSchedule(cron)
+		Ω(err).ShouldNot(HaveOccurred())
+		schedule, err = ctrler.GetSchedule()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(schedule.String()).Should(Equal("CRON 0 6 * * 1-5"))
+
+		files, err = ctrler.Client.ListFiles()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(files).Should(Equal([]string{"SCHEDULE.json"}))
+	})
+
+	It("round-trips every part of the schedule document", func() {
+		schedule, err := ParseSchedule("MONTHLY 28")
+		Ω(err).ShouldNot(HaveOccurred())
+		schedule.Timezone = "Europe/London"
+		schedule.FilePattern = "extract-*.csv"
+		schedule.Window, err = ParseArrivalWindow("23:30-01:00")
+		Ω(err).ShouldNot(HaveOccurred())
+		schedule.GracePeriod = 90 * time.Minute
+		schedule.OwnerContact = "ops@example.com"
+
+		_, err = ctrler.SetSchedule(schedule)
+		Ω(err).ShouldNot(HaveOccurred())
+		stored, err := ctrler.GetSchedule()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(stored).Should(Equal(schedule))
+	})
+
+	It("reads schedules stored before the schedule document", func() {
+		store.Put("myintegrator", "myclient/WEEKLY_SCHEDULE", []byte("WEEKLY tue\n"), time.Now())
+		schedule, err := ctrler.GetSchedule()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(schedule.String()).Should(Equal("WEEKLY tue"))
+
+		_, err = ctrler.SetSchedule(Schedule{Interval: DailyInterval})
+		Ω(err).ShouldNot(HaveOccurred())
+		// the schedule is looked up by name, without listing every file of the client
+		Ω(store.Calls("ListFiles")).Should(BeZero())
+		files, err = ctrler.Client.ListFiles()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(files).Should(Equal([]string{"SCHEDULE.json"}))
+	})
+
+	It("stores the schedule & retention as they are, when data files are compressed & encrypted", func() {
+		kmsDir, err := ioutil.TempDir("", "controller-kms")
+		Ω(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(kmsDir)
+		kms := iaas.LocalKMS{Dir: kmsDir, KeyId: "myclient"}
+		Ω(kms.CreateKey()).Should(Succeed())
+		ctrler = Controller{Client: memory.Client{Store: store, IntegratorId: "myintegrator", ClientId: "myclient", Compression: "gzip", Encryption: kms}}
+
+		_, err = ctrler.SetSchedule(Schedule{Interval: DailyInterval})
+		Ω(err).ShouldNot(HaveOccurred())
+		var retention Retention
+		Ω(retention.Set(InputFolder, 30)).Should(Succeed())
+		Ω(ctrler.SetRetention(retention)).Should(Succeed())
+
+		stored, _ := store.Get("myintegrator", "myclient/SCHEDULE.json")
+		Ω(string(stored)).Should(ContainSubstring(`"interval": "DAILY"`))
+		stored, _ = store.Get("myintegrator", "myclient/RETENTION.json")
+		Ω(string(stored)).Should(ContainSubstring(`"days": 30`))
+
+		info, err := ctrler.Client.StatFile("SCHEDULE.json")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(info.Compression).Should(BeEmpty())
+		Ω(info.EncryptionKeyId).Should(BeEmpty())
+
+		schedule, err := ctrler.GetSchedule()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(schedule.String()).Should(Equal("DAILY"))
+		Ω(ctrler.GetRetention()).Should(Equal(retention))
+	})
+
+	It("keeps the existing schedule when the new one cannot be uploaded", func() {
+		store.Put("myintegrator", "myclient/WEEKLY_SCHEDULE", []byte("WEEKLY tue\n"), time.Now())
+		store.FailTimes("UploadFile", 1, errors.New("RequestError: send request failed"))
+		_, err = ctrler.SetSchedule(Schedule{Interval: DailyInterval})
+		Ω(err).Should(HaveOccurred())
+
+		schedule, err := ctrler.GetSchedule()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(schedule.String()).Should(Equal("WEEKLY tue"))
+	})
+
+	It("refuses schedule documents from a later release", func() {
+		store.Put("myintegrator", "myclient/SCHEDULE.json", []byte(`{"version": 2, "interval": "DAILY"}`), time.Now())
+		_, err = ctrler.GetSchedule()
+		Ω(err).Should(MatchError(ContainSubstring("Unsupported schedule document version 2")))
+	})
+
+	It("pauses & resumes the schedule, keeping its details", func() {
+		err := ctrler.PauseSchedule(time.Time{}, time.Now())
+		Ω(err).Should(MatchError("No schedule is set to pause"))
+
+		_, err = ctrler.SetSchedule(Schedule{Interval: WeeklyInterval, Weekday: time.Monday, Timezone: "Europe/London", OwnerContact: "ops@example.com"})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(ctrler.PauseSchedule(time.Time{}, time.Now())).Should(Succeed())
+
+		schedule, err := ctrler.GetSchedule()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(schedule.Pause.IsSet()).Should(BeTrue())
+		Ω(schedule.String()).Should(Equal("WEEKLY mon"))
+
+		status, err = ctrler.ResumeSchedule()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(status).Should(BeTrue())
+		schedule, err = ctrler.GetSchedule()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(schedule).Should(Equal(Schedule{Interval: WeeklyInterval, Weekday: time.Monday, Timezone: "Europe/London", OwnerContact: "ops@example.com"}))
+
+		status, err = ctrler.ResumeSchedule()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(status).Should(BeFalse())
+	})
+
+	It("keeps the pause when the schedule is replaced", func() {
+		_, err = ctrler.SetSchedule(Schedule{Interval: DailyInterval})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(ctrler.PauseSchedule(time.Time{}, time.Now())).Should(Succeed())
+
+		_, err = ctrler.SetSchedule(Schedule{Interval: WeeklyInterval, Weekday: time.Tuesday})
+		Ω(err).ShouldNot(HaveOccurred())
+		schedule, err := ctrler.GetSchedule()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(schedule.String()).Should(Equal("WEEKLY tue"))
+		Ω(schedule.Pause.IsSet()).Should(BeTrue())
+	})
+
+	It("removes the schedule and upload notification along with the client user", func() {
+		_, err = ctrler.CreateClientUser()
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = ctrler.SetSchedule(Schedule{Interval: DailyInterval})
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = ctrler.EnableImmediateDataFileCollection()
+		Ω(err).ShouldNot(HaveOccurred())
+
+		status, err = ctrler.DeleteClientUser(false)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(status).Should(BeTrue())
+
+		schedule, err := ctrler.GetSchedule()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(schedule.String()).Should(Equal("NONE"))
+		status, err = ctrler.ImmediateDataFileCollectionStatus()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(status).Should(BeFalse())
+	})
+
+	It("lists data files with the checksums stored on upload", func() {
+		_, err := ctrler.UploadDataFile("../iaas/fixtures/test-file.csv")
+		Ω(err).ShouldNot(HaveOccurred())
+		store.Put("myintegrator", "myclient/INPUT/legacy.csv", []byte("a,b"), time.Now())
+
+		var files []iaas.FileInfo
+		_, err = ctrler.WalkDataFiles(ListOptions{WithDetails: true}, func(file iaas.FileInfo) error {
+			files = append(files, file)
+			return nil
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(files).Should(HaveLen(2))
+		Ω(files[0].Name).Should(Equal("INPUT/legacy.csv"))
+		Ω(files[0].Checksum).Should(BeEmpty())
+		Ω(files[1].Name).Should(Equal("INPUT/test-file.csv"))
+		Ω(files[1].Checksum).Should(Equal("d3e883a63131eff319103ed5b6ffd2e8ef0e60d441e3ed69d78417efccb0d186"))
+
+		files = nil
+		_, err = ctrler.WalkDataFiles(ListOptions{}, func(file iaas.FileInfo) error {
+			files = append(files, file)
+			return nil
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(files[1].Checksum).Should(BeEmpty())
+	})
+
+	It("reports an upload that cannot be confirmed", func() {
+		store.FailOn("StatFile", errors.New("RequestError: send request failed"))
+		_, err = ctrler.UploadDataFile("../iaas/fixtures/test-file.csv")
+		Ω(err).Should(MatchError("Unable to find uploaded file INPUT/test-file.csv: RequestError: send request failed"))
+		Ω(store.Calls("UploadFile")).Should(Equal(1))
+	})
+
+	It("verifies & downloads a compressed upload", func() {
+		ctrler = Controller{Client: memory.Client{Store: store, IntegratorId: "myintegrator", ClientId: "myclient", Compression: "gzip"}}
+		_, err = ctrler.UploadDataFile("../iaas/fixtures/test-file.csv")
+		Ω(err).ShouldNot(HaveOccurred())
+		stored, _ := store.Get("myintegrator", "myclient/INPUT/test-file.csv")
+		Ω(len(stored)).Should(BeNumerically("<", 1089))
+
+		downloadDir, err := ioutil.TempDir("", "controller-download")
+		Ω(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(downloadDir)
+		localPath, err := ctrler.DownloadDataFile("INPUT/test-file.csv", downloadDir)
+		Ω(err).ShouldNot(HaveOccurred())
+		expected, _ := ioutil.ReadFile("../iaas/fixtures/test-file.csv")
+		Ω(ioutil.ReadFile(localPath)).Should(Equal(expected))
+	})
+
+	Context("when collecting data files", func() {
+		var downloadDir string
+
+		BeforeEach(func() {
+			downloadDir, err = ioutil.TempDir("", "controller-collect")
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = ctrler.UploadDataFileAs("../iaas/fixtures/test-file.csv", "a.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = ctrler.UploadDataFileAs("../iaas/fixtures/test-file.csv", "b.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(downloadDir)
+		})
+
+		It("downloads every file & moves it out of INPUT/", func() {
+			results, err := ctrler.CollectDataFiles(downloadDir, ArchiveFolder)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(results).Should(HaveLen(2))
+			for _, result := range results {
+				Ω(result.Err).ShouldNot(HaveOccurred())
+				Ω(result.LocalPath).Should(BeAnExistingFile())
+			}
+			Ω(results[0].MovedTo).Should(Equal("ARCHIVE/a.csv"))
+
+			Ω(ctrler.ListDataFiles()).Should(BeEmpty())
+			Ω(ctrler.ListProcessedDataFiles()).Should(Equal([]string{"ARCHIVE/a.csv", "ARCHIVE/b.csv"}))
+		})
+
+		It("leaves a file that could not be downloaded to be collected again", func() {
+			store.FailTimes("GetFile", 1, errors.New("RequestError: send request failed"))
+			results, err := ctrler.CollectDataFiles(downloadDir, ProcessedFolder)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(results[0].Err).Should(HaveOccurred())
+			Ω(results[0].MovedTo).Should(BeEmpty())
+			Ω(results[1].MovedTo).Should(Equal("PROCESSED/b.csv"))
+
+			Ω(ctrler.ListDataFiles()).Should(Equal([]string{"INPUT/a.csv"}))
+		})
+
+		It("leaves a file that was skipped, as it was already downloaded, in INPUT/", func() {
+			ctrler = Controller{Client: memory.Client{Store: store, IntegratorId: "myintegrator", ClientId: "myclient", OnConflict: iaas.SkipExisting}}
+			_, err = ctrler.DownloadDataFile("INPUT/a.csv", downloadDir)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			results, err := ctrler.CollectDataFiles(downloadDir, ProcessedFolder)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(results[0].Err).ShouldNot(HaveOccurred())
+			Ω(results[0].Skipped).Should(BeTrue())
+			Ω(results[0].MovedTo).Should(BeEmpty())
+			Ω(results[1].Skipped).Should(BeFalse())
+			Ω(results[1].MovedTo).Should(Equal("PROCESSED/b.csv"))
+
+			Ω(ctrler.ListDataFiles()).Should(Equal([]string{"INPUT/a.csv"}))
+		})
+
+		It("only moves files in INPUT/ to a known folder", func() {
+			_, err = ctrler.MoveDataFile("PROCESSED/a.csv", ArchiveFolder)
+			Ω(err).Should(MatchError("Only files in INPUT/ can be moved, not PROCESSED/a.csv"))
+			_, err = ParseFolder("done")
+			Ω(err).Should(MatchError("Unknown folder done, expected processed or archive"))
+		})
+	})
+
+	It("walks the data files under a prefix, up to a limit", func() {
+		for _, name := range []string{"2017/a.csv", "2017/b.csv", "2018/c.csv"} {
+			_, err = ctrler.UploadDataFileAs("../iaas/fixtures/test-file.csv", name)
+			Ω(err).ShouldNot(HaveOccurred())
+		}
+
+		var names []string
+		walk := func(file iaas.FileInfo) error {
+			names = append(names, file.Name)
+			return nil
+		}
+		truncated, err := ctrler.WalkDataFiles(ListOptions{Prefix: "2017/"}, walk)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(truncated).Should(BeFalse())
+		Ω(names).Should(Equal([]string{"INPUT/2017/a.csv", "INPUT/2017/b.csv"}))
+
+		names = nil
+		truncated, err = ctrler.WalkDataFiles(ListOptions{Limit: 2, WithDetails: true}, walk)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(truncated).Should(BeTrue())
+		Ω(names).Should(Equal([]string{"INPUT/2017/a.csv", "INPUT/2017/b.csv"}))
+		Ω(store.Calls("StatFile")).Should(Equal(3 + 2))
+	})
+
+	It("deletes the data files under a prefix & older than an age, in a batch", func() {
+		now := time.Date(2026, 10, 14, 3, 0, 0, 0, time.UTC)
+		store.Put("myintegrator", "myclient/INPUT/2017/old.csv", []byte("a,b"), now.Add(-48*time.Hour))
+		store.Put("myintegrator", "myclient/INPUT/2017/new.csv", []byte("a,b"), now.Add(-time.Hour))
+		store.Put("myintegrator", "myclient/INPUT/2018/old.csv", []byte("a,b"), now.Add(-48*time.Hour))
+		store.Put("myintegrator", "myclient/PROCESSED/2017/old.csv", []byte("a,b"), now.Add(-48*time.Hour))
+
+		options := DeleteOptions{Prefix: "2017/", OlderThan: 24 * time.Hour, DryRun: true}
+		files, err := ctrler.DeleteDataFiles(options, now)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(files).Should(HaveLen(1))
+		Ω(files[0].Name).Should(Equal("INPUT/2017/old.csv"))
+		Ω(store.Calls("DeleteFiles")).Should(Equal(0))
+
+		options.DryRun = false
+		files, err = ctrler.DeleteDataFiles(options, now)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(files).Should(HaveLen(1))
+		Ω(store.Calls("DeleteFiles")).Should(Equal(1))
+		Ω(ctrler.ListDataFiles()).Should(Equal([]string{"INPUT/2017/new.csv", "INPUT/2018/old.csv"}))
+		Ω(ctrler.ListProcessedDataFiles()).Should(Equal([]string{"PROCESSED/2017/old.csv"}))
+
+		_, err = ctrler.DeleteDataFiles(DeleteOptions{OlderThan: -time.Hour}, now)
+		Ω(err).Should(MatchError("The age of files to delete must not be negative"))
+	})
+	It("stores retention rules & sweeps the expired files where the store has no lifecycle support", func() {
+		now := time.Date(2026, 10, 14, 3, 0, 0, 0, time.UTC)
+		store.Put("myintegrator", "myclient/INPUT/old.csv", []byte("a,b"), now.Add(-31*24*time.Hour))
+		store.Put("myintegrator", "myclient/INPUT/new.csv", []byte("a,b"), now.Add(-29*24*time.Hour))
+		store.Put("myintegrator", "myclient/ARCHIVE/old.csv", []byte("a,b"), now.Add(-31*24*time.Hour))
+
+		retention, err := ctrler.GetRetention()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(retention.IsSet()).Should(BeFalse())
+
+		Ω(retention.Set(ArchiveFolder, 365)).Should(Succeed())
+		Ω(retention.Set(InputFolder, 30)).Should(Succeed())
+		Ω(retention.Set("OUTPUT/", 30)).ShouldNot(Succeed())
+		Ω(retention.Set(InputFolder, -1)).Should(MatchError("Retention days must not be negative"))
+		Ω(ctrler.SetRetention(retention)).Should(Succeed())
+
+		retention, err = ctrler.GetRetention()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(retention.Rules).Should(Equal([]RetentionRule{{Folder: ArchiveFolder, Days: 365}, {Folder: InputFolder, Days: 30}}))
+
+		report, err := ctrler.ApplyRetention(false, now)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(report.Lifecycle).Should(BeFalse())
+		Ω(store.Calls("PutLifecycleRules")).Should(Equal(1))
+		Ω(store.Calls("ListFiles")).Should(BeZero())
+		Ω(report.Deleted).Should(HaveLen(1))
+		Ω(report.Deleted[0].Name).Should(Equal("INPUT/old.csv"))
+		Ω(ctrler.ListDataFiles()).Should(Equal([]string{"INPUT/new.csv"}))
+		Ω(ctrler.ListProcessedDataFiles()).Should(Equal([]string{"ARCHIVE/old.csv"}))
+
+		Ω(retention.Set(InputFolder, 0)).Should(Succeed())
+		Ω(retention.Set(ArchiveFolder, 0)).Should(Succeed())
+		Ω(ctrler.SetRetention(retention)).Should(Succeed())
+		Ω(ctrler.Client.ListFiles()).ShouldNot(ContainElement(RetentionFileName))
+	})
+
+	It("refuses retention documents from a later release", func() {
+		store.Put("myintegrator", "myclient/"+RetentionFileName, []byte(`{"version": 2, "rules": []}`), time.Now())
+		_, err := ctrler.GetRetention()
+		Ω(err).Should(MatchError("Unsupported retention document version 2, this release supports up to version 1"))
+	})
+})
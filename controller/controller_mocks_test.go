@@ -1,11 +1,18 @@
 package controller_test
 
-import "github.com/dhrapson/sched-load/iaas"
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/dhrapson/sched-load/iaas"
+)
 
 type IaaSClientMock struct {
 	Credentials   iaas.IaaSCredentials
 	AccountDetail iaas.IaaSAccountDetails
 	FilesList     []string
+	FileInfo      iaas.FileInfo
 	FileName      string
 	FilePath      string
 	Success       bool
@@ -19,11 +26,14 @@ func (client IaaSClientMock) ListFiles() (names []string, err error) {
 	return client.FilesList, nil
 }
 
-func (client IaaSClientMock) UploadFile(filepath string, targetName string) (name string, err error) {
+func (client IaaSClientMock) UploadFile(filepath string, targetName string) (name string, checksum string, err error) {
 	if client.Err != nil {
-		return "", client.Err
+		return "", "", client.Err
 	}
-	return client.FileName, nil
+	if checksum, _, err = iaas.ChecksumFile(filepath); err != nil {
+		return
+	}
+	return client.FileName, checksum, nil
 }
 
 func (client IaaSClientMock) GetFile(remotePath string, localDir string) (downloadedFilePath string, err error) {
@@ -40,6 +50,17 @@ func (client IaaSClientMock) DeleteFile(remotePath string) (result bool, err err
 	return client.Success, nil
 }
 
+func (client IaaSClientMock) DeleteFiles(remotePaths []string) (deleted []string, err error) {
+	if client.Err != nil {
+		return nil, client.Err
+	}
+	return remotePaths, nil
+}
+
+func (client IaaSClientMock) MoveFile(sourcePath string, targetPath string) (err error) {
+	return client.Err
+}
+
 func (client IaaSClientMock) AddFileUploadNotification() (wasNewConfiguration bool, err error) {
 	if client.Err != nil {
 		return false, client.Err
@@ -81,3 +102,79 @@ func (client IaaSClientMock) AccountDetails() (details iaas.IaaSAccountDetails,
 	}
 	return client.AccountDetail, nil
 }
+
+func (client IaaSClientMock) ListFileInfos() (files []iaas.FileInfo, err error) {
+	if client.Err != nil {
+		return nil, client.Err
+	}
+	for _, name := range client.FilesList {
+		files = append(files, iaas.FileInfo{Name: name})
+	}
+	return
+}
+
+func (client IaaSClientMock) ListClients() (clientIds []string, err error) {
+	if client.Err != nil {
+		return nil, client.Err
+	}
+	return []string{client.AccountDetail["ClientId"]}, nil
+}
+
+func (client IaaSClientMock) ForClient(clientId string) iaas.IaaSClient {
+	return client
+}
+
+func (client IaaSClientMock) WithoutEncoding() iaas.IaaSClient {
+	return client
+}
+
+func (client IaaSClientMock) AbortIncompleteUploads(initiatedBefore time.Time) (names []string, err error) {
+	if client.Err != nil {
+		return nil, client.Err
+	}
+	return client.FilesList, nil
+}
+
+func (client IaaSClientMock) EncryptionStatus() (description string, err error) {
+	if client.Err != nil {
+		return "", client.Err
+	}
+	return iaas.SSEAES256, nil
+}
+
+func (client IaaSClientMock) WalkFiles(prefix string, walkFn iaas.WalkFunc) (err error) {
+	if client.Err != nil {
+		return client.Err
+	}
+	for _, name := range client.FilesList {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if err = walkFn(iaas.FileInfo{Name: name}); err != nil {
+			break
+		}
+	}
+	if err == iaas.StopWalk {
+		return nil
+	}
+	return
+}
+
+func (client IaaSClientMock) PutLifecycleRules(rules []iaas.LifecycleRule) (supported bool, err error) {
+	return client.Success, client.Err
+}
+
+func (client IaaSClientMock) StatFile(remotePath string) (info iaas.FileInfo, err error) {
+	if client.Err != nil {
+		return info, client.Err
+	}
+	if client.FileInfo.Name != "" {
+		return client.FileInfo, nil
+	}
+	for _, name := range client.FilesList {
+		if name == remotePath {
+			return iaas.FileInfo{Name: name}, nil
+		}
+	}
+	return info, errors.New("NotFound: The specified key does not exist: " + remotePath)
+}
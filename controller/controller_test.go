@@ -38,6 +38,7 @@ var _ = Describe("The controller", func() {
 				Ω(details["ClientId"]).Should(Equal("456"))
 				Ω(details["IntegratorId"]).Should(Equal("789"))
 				Ω(details["ConnectionType"]).Should(Equal("client"))
+				Ω(details["Encryption"]).Should(Equal("AES256"))
 			})
 		})
 
@@ -332,12 +333,12 @@ var _ = Describe("The controller", func() {
 	Describe("the UploadDataFile operation", func() {
 		var result string
 		JustBeforeEach(func() {
-			result, err = controller.UploadDataFile("path/to/thefile")
+			result, err = controller.UploadDataFile("../iaas/fixtures/test-file.csv")
 		})
 
 		Context("when the IaaS is connecting", func() {
 			BeforeEach(func() {
-				iaasClient = IaaSClientMock{FilesList: []string{"somefile", "thefile", "otherfile"}, FileName: "thefile"}
+				iaasClient = IaaSClientMock{FileName: "thefile", FileInfo: iaas.FileInfo{Name: "thefile", Size: 1089, Checksum: "d3e883a63131eff319103ed5b6ffd2e8ef0e60d441e3ed69d78417efccb0d186"}}
 			})
 			It("gives uploaded result", func() {
 				Ω(err).ShouldNot(HaveOccurred())
@@ -345,6 +346,16 @@ var _ = Describe("The controller", func() {
 			})
 		})
 
+		Context("when the uploaded file has a different checksum", func() {
+			BeforeEach(func() {
+				iaasClient = IaaSClientMock{FileName: "thefile", FileInfo: iaas.FileInfo{Name: "thefile", Size: 1089, Checksum: "0123"}}
+			})
+			It("throws an error and returns the right result", func() {
+				Ω(err).Should(MatchError(ContainSubstring("has checksum '0123'")))
+				Ω(result).Should(Equal("error"))
+			})
+		})
+
 		Context("when the IaaS is not connecting", func() {
 			BeforeEach(func() {
 				iaasClient = IaaSClientMock{Err: errors.New("InvalidAccessKeyId")}
@@ -359,12 +370,12 @@ var _ = Describe("The controller", func() {
 	Describe("the SetSchedule operation", func() {
 		var result bool
 		JustBeforeEach(func() {
-			result, err = controller.SetSchedule("DAILY")
+			result, err = controller.SetSchedule(Schedule{Interval: DailyInterval})
 		})
 
 		Context("when the IaaS is connecting", func() {
 			BeforeEach(func() {
-				iaasClient = IaaSClientMock{FileName: "DAILY_SCHEDULE"}
+				iaasClient = IaaSClientMock{FileName: "SCHEDULE.json"}
 			})
 			It("gives set", func() {
 				Ω(err).ShouldNot(HaveOccurred())
@@ -393,7 +404,7 @@ var _ = Describe("The controller", func() {
 
 			Context("when the schedule was previously set", func() {
 				BeforeEach(func() {
-					iaasClient = IaaSClientMock{Success: true}
+					iaasClient = IaaSClientMock{FilesList: []string{"INPUT/somefile.txt", "WEEKLY_SCHEDULE"}, Success: true}
 				})
 				It("indicates success in setting schedule", func() {
 					Ω(err).ShouldNot(HaveOccurred())
@@ -424,7 +435,7 @@ var _ = Describe("The controller", func() {
 	})
 
 	Describe("the GetSchedule operation", func() {
-		var result string
+		var result Schedule
 		JustBeforeEach(func() {
 			result, err = controller.GetSchedule()
 		})
@@ -437,7 +448,7 @@ var _ = Describe("The controller", func() {
 				})
 				It("shows that none is set", func() {
 					Ω(err).ShouldNot(HaveOccurred())
-					Ω(result).Should(Equal("NONE"))
+					Ω(result.String()).Should(Equal("NONE"))
 				})
 			})
 
@@ -447,7 +458,7 @@ var _ = Describe("The controller", func() {
 				})
 				It("returns a daily status", func() {
 					Ω(err).ShouldNot(HaveOccurred())
-					Ω(result).Should(Equal("DAILY"))
+					Ω(result.String()).Should(Equal("DAILY"))
 				})
 			})
 		})
@@ -458,7 +469,7 @@ var _ = Describe("The controller", func() {
 			})
 			It("throws an error and returns the right result", func() {
 				Ω(err).Should(HaveOccurred())
-				Ω(result).Should(Equal("ERROR"))
+				Ω(result.IsSet()).Should(BeFalse())
 			})
 		})
 	})
@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSpec is a parsed standard 5 field cron expression: minute hour day-of-month month day-of-week
+type cronSpec struct {
+	minutes     map[int]bool
+	hours       map[int]bool
+	daysOfMonth map[int]bool
+	months      map[int]bool
+	daysOfWeek  map[int]bool
+	// as with cron, when both day fields are restricted a day matching either is a match
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+func parseCron(expression string) (spec cronSpec, err error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		err = errors.New("Cron expression must have 5 fields: minute hour day-of-month month day-of-week")
+		return
+	}
+
+	if spec.minutes, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return
+	}
+	if spec.hours, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return
+	}
+	if spec.daysOfMonth, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return
+	}
+	if spec.months, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return
+	}
+	if spec.daysOfWeek, err = parseCronField(fields[4], 0, 7, dayNames); err != nil {
+		return
+	}
+	// both 0 and 7 mean Sunday
+	if spec.daysOfWeek[7] {
+		spec.daysOfWeek[0] = true
+	}
+	spec.anyDayOfMonth = strings.HasPrefix(fields[2], "*")
+	spec.anyDayOfWeek = strings.HasPrefix(fields[4], "*")
+	return
+}
+
+func parseCronField(field string, min int, max int, names map[string]int) (values map[int]bool, err error) {
+	values = map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if slash := strings.Index(part, "/"); slash >= 0 {
+			if step, err = strconv.Atoi(part[slash+1:]); err != nil || step < 1 {
+				return nil, errors.New("Invalid step in cron field: " + field)
+			}
+			part = part[:slash]
+		}
+
+		start, end := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			if start, err = parseCronValue(bounds[0], names); err != nil {
+				return nil, errors.New("Invalid value in cron field: " + field)
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = parseCronValue(bounds[1], names); err != nil {
+					return nil, errors.New("Invalid range in cron field: " + field)
+				}
+			} else if step > 1 {
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return nil, errors.New("Out of range value in cron field: " + field)
+		}
+
+		for value := start; value <= end; value += step {
+			values[value] = true
+		}
+	}
+	return
+}
+
+func parseCronValue(value string, names map[string]int) (int, error) {
+	if number, found := names[strings.ToLower(value)]; found {
+		return number, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func (spec cronSpec) matchesDay(t time.Time) bool {
+	dayOfMonth := spec.daysOfMonth[t.Day()]
+	dayOfWeek := spec.daysOfWeek[int(t.Weekday())]
+	switch {
+	case spec.anyDayOfMonth && spec.anyDayOfWeek:
+		return true
+	case spec.anyDayOfMonth:
+		return dayOfWeek
+	case spec.anyDayOfWeek:
+		return dayOfMonth
+	default:
+		return dayOfMonth || dayOfWeek
+	}
+}
+
+// next gives the first time after t that matches the expression, in t's location
+func (spec cronSpec) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// five years is enough for any satisfiable expression, e.g. 29th of February
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !spec.months[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !spec.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !spec.hours[t.Hour()] {
+			// move on in elapsed time rather than by wall clock, so neither of the hours repeated when the clocks go back is skipped
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			continue
+		}
+		if !spec.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
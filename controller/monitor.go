@@ -0,0 +1,185 @@
+package controller
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dhrapson/sched-load/iaas"
+)
+
+const (
+	ArrivalOk          = "ok"
+	ArrivalOverdue     = "overdue"
+	ArrivalUnscheduled = "unscheduled"
+	ArrivalPaused      = "paused"
+	ArrivalCheckFailed = "error"
+)
+
+// lookbacks are tried in turn when searching for the most recent arrivals, so frequent schedules stay cheap to check
+var lookbacks = []time.Duration{48 * time.Hour, 32 * 24 * time.Hour, 367 * 24 * time.Hour, arrivalHorizon}
+
+// ArrivalReport is the outcome of checking that a client's most recently expected file arrived
+type ArrivalReport struct {
+	ClientId     string     `json:"client_id"`
+	Status       string     `json:"status"`
+	Schedule     string     `json:"schedule,omitempty"`
+	Expected     *Arrival   `json:"expected,omitempty"`
+	LastUpload   *time.Time `json:"last_upload,omitempty"`
+	OwnerContact string     `json:"owner_contact,omitempty"`
+	PausedUntil  *time.Time `json:"paused_until,omitempty"`
+	Error        string     `json:"error,omitempty"`
+}
+
+// CheckArrivals checks every client of the integrator for an overdue file
+func (controller Controller) CheckArrivals(now time.Time) (reports []ArrivalReport, err error) {
+	clientIds, err := controller.Client.ListClients()
+	if err != nil {
+		return
+	}
+
+	reports = []ArrivalReport{}
+	for _, clientId := range clientIds {
+		reports = append(reports, controller.CheckClientArrival(clientId, now))
+	}
+	return
+}
+
+// CheckClientArrival reports a client as overdue when no file matching its schedule has arrived in INPUT/
+// between the deadline of the previous expected arrival & the deadline of the most recent one.
+// Arrivals expected while the schedule was paused are skipped.
+func (controller Controller) CheckClientArrival(clientId string, now time.Time) (report ArrivalReport) {
+	report = ArrivalReport{ClientId: clientId}
+	clientController := Controller{Client: controller.Client.ForClient(clientId)}
+
+	schedule, err := clientController.GetSchedule()
+	if err != nil {
+		return report.failed(err)
+	}
+	report.Schedule = schedule.String()
+	report.OwnerContact = schedule.OwnerContact
+	if !schedule.IsSet() {
+		report.Status = ArrivalUnscheduled
+		return
+	}
+	if schedule.Pause.Covers(now) {
+		report.Status = ArrivalPaused
+		if !schedule.Pause.Until.IsZero() {
+			until := schedule.Pause.Until.UTC()
+			report.PausedUntil = &until
+		}
+		return
+	}
+
+	expected, since, found, err := schedule.lastArrival(now)
+	if err != nil {
+		return report.failed(err)
+	}
+
+	lastUpload, err := clientController.latestUpload(schedule.FilePattern)
+	if err != nil {
+		return report.failed(err)
+	}
+	if !lastUpload.IsZero() {
+		report.LastUpload = &lastUpload
+	}
+
+	report.Status = ArrivalOk
+	if found {
+		report.Expected = &expected
+		if !lastUpload.After(since) {
+			report.Status = ArrivalOverdue
+		}
+	}
+	return
+}
+
+func (report ArrivalReport) failed(err error) ArrivalReport {
+	report.Status = ArrivalCheckFailed
+	report.Error = err.Error()
+	return report
+}
+
+// lastArrival gives the most recent arrival whose deadline has passed, along with the deadline of the arrival before it,
+// which is zero when there was none
+func (schedule Schedule) lastArrival(now time.Time) (last Arrival, since time.Time, found bool, err error) {
+	for _, lookback := range lookbacks {
+		var previous Arrival
+		count := 0
+		err = schedule.eachArrival(now.Add(-lookback), func(arrival Arrival) bool {
+			if arrival.Deadline.After(now) {
+				return false
+			}
+			previous, last = last, arrival
+			count++
+			return true
+		})
+		if err != nil {
+			return
+		}
+		if count >= 2 {
+			return last, previous.Deadline, true, nil
+		}
+		if count == 1 {
+			found = true
+		}
+	}
+	return
+}
+
+// latestUpload gives when the most recent data file matching the pattern arrived. Files in INPUT/ arrived when
+// last modified, whereas files moved to PROCESSED/ or ARCHIVE/ keep when they arrived in their metadata, which is
+// only read for the files moved since the latest file in INPUT/ arrived.
+func (controller Controller) latestUpload(pattern string) (latest time.Time, err error) {
+	matches := func(file iaas.FileInfo) bool {
+		// folder markers, e.g. INPUT/ itself, are not data files
+		if strings.HasSuffix(file.Name, "/") {
+			return false
+		}
+		if pattern == "" {
+			return true
+		}
+		matched, _ := path.Match(pattern, path.Base(file.Name))
+		return matched
+	}
+
+	err = controller.Client.WalkFiles(InputFolder, func(file iaas.FileInfo) error {
+		if matches(file) && file.LastModified.After(latest) {
+			latest = file.LastModified
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	var moved []iaas.FileInfo
+	for _, folder := range []string{ProcessedFolder, ArchiveFolder} {
+		err = controller.Client.WalkFiles(folder, func(file iaas.FileInfo) error {
+			if matches(file) && file.LastModified.After(latest) {
+				moved = append(moved, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	// a file arrived no later than it was moved, so the files moved most recently are checked first
+	sort.Slice(moved, func(i, j int) bool { return moved[i].LastModified.After(moved[j].LastModified) })
+	for _, file := range moved {
+		if !file.LastModified.After(latest) {
+			break
+		}
+		var info iaas.FileInfo
+		if info, err = controller.Client.StatFile(file.Name); err != nil {
+			return
+		}
+		if info.UploadedAt.After(latest) {
+			latest = info.UploadedAt
+		}
+	}
+	return
+}
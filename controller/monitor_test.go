@@ -0,0 +1,162 @@
+package controller_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "github.com/dhrapson/sched-load/controller"
+	"github.com/dhrapson/sched-load/iaas/memory"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Checking for missed uploads", func() {
+
+	var (
+		store   *memory.Store
+		ctrler  Controller
+		reports []ArrivalReport
+		err     error
+	)
+
+	// 2026-10-14 is a Wednesday
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	daily := Schedule{Interval: DailyInterval, Timezone: "UTC", Window: ArrivalWindow{Start: 2 * time.Hour, End: 4 * time.Hour}, GracePeriod: time.Hour, OwnerContact: "ops@example.com"}
+
+	setSchedule := func(clientId string, schedule Schedule) {
+		clientController := Controller{Client: memory.Client{Store: store, IntegratorId: "myintegrator", ClientId: clientId}}
+		_, setErr := clientController.SetSchedule(schedule)
+		Ω(setErr).ShouldNot(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		store = memory.NewStore()
+		ctrler = Controller{Client: memory.Client{Store: store, IntegratorId: "myintegrator"}}
+	})
+
+	It("reports each client's status", func() {
+		setSchedule("ontime", daily)
+		store.Put("myintegrator", "ontime/INPUT/extract.csv", []byte("a,b"), time.Date(2026, 10, 14, 3, 0, 0, 0, time.UTC))
+		setSchedule("late", daily)
+		store.Put("myintegrator", "late/INPUT/extract.csv", []byte("a,b"), time.Date(2026, 10, 13, 3, 0, 0, 0, time.UTC))
+		store.Put("myintegrator", "unscheduled/INPUT/extract.csv", []byte("a,b"), now)
+
+		reports, err = ctrler.CheckArrivals(now)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(reports).Should(HaveLen(3))
+		// only the data file folders are walked, rather than listing every file of each client
+		Ω(store.Calls("ListFileInfos")).Should(BeZero())
+
+		Ω(reports[0].ClientId).Should(Equal("late"))
+		Ω(reports[0].Status).Should(Equal(ArrivalOverdue))
+		Ω(reports[0].Schedule).Should(Equal("DAILY"))
+		Ω(reports[0].OwnerContact).Should(Equal("ops@example.com"))
+		Ω(*reports[0].Expected).Should(Equal(Arrival{
+			Start:    time.Date(2026, 10, 14, 2, 0, 0, 0, time.UTC),
+			End:      time.Date(2026, 10, 14, 4, 0, 0, 0, time.UTC),
+			Deadline: time.Date(2026, 10, 14, 5, 0, 0, 0, time.UTC),
+		}))
+		Ω(*reports[0].LastUpload).Should(Equal(time.Date(2026, 10, 13, 3, 0, 0, 0, time.UTC)))
+
+		Ω(reports[1].ClientId).Should(Equal("ontime"))
+		Ω(reports[1].Status).Should(Equal(ArrivalOk))
+
+		Ω(reports[2].ClientId).Should(Equal("unscheduled"))
+		Ω(reports[2].Status).Should(Equal(ArrivalUnscheduled))
+		Ω(reports[2].Expected).Should(BeNil())
+	})
+
+	It("is not overdue until the grace period has passed", func() {
+		setSchedule("myclient", daily)
+		store.Put("myintegrator", "myclient/INPUT/extract.csv", []byte("a,b"), time.Date(2026, 10, 13, 3, 0, 0, 0, time.UTC))
+
+		report := ctrler.CheckClientArrival("myclient", time.Date(2026, 10, 14, 4, 30, 0, 0, time.UTC))
+		Ω(report.Status).Should(Equal(ArrivalOk))
+		Ω(report.Expected.Start).Should(Equal(time.Date(2026, 10, 13, 2, 0, 0, 0, time.UTC)))
+
+		report = ctrler.CheckClientArrival("myclient", time.Date(2026, 10, 14, 5, 0, 0, 0, time.UTC))
+		Ω(report.Status).Should(Equal(ArrivalOverdue))
+	})
+
+	It("only counts files matching the schedule's pattern", func() {
+		schedule := daily
+		schedule.FilePattern = "extract-*.csv"
+		setSchedule("myclient", schedule)
+		store.Put("myintegrator", "myclient/INPUT/other.csv", []byte("a,b"), time.Date(2026, 10, 14, 3, 0, 0, 0, time.UTC))
+
+		Ω(ctrler.CheckClientArrival("myclient", now).Status).Should(Equal(ArrivalOverdue))
+
+		store.Put("myintegrator", "myclient/INPUT/extract-20261014.csv", []byte("a,b"), time.Date(2026, 10, 14, 3, 0, 0, 0, time.UTC))
+		Ω(ctrler.CheckClientArrival("myclient", now).Status).Should(Equal(ArrivalOk))
+	})
+
+	It("counts files that were collected, by when they arrived rather than when they were moved", func() {
+		setSchedule("collected", daily)
+		store.Put("myintegrator", "collected/INPUT/extract.csv", []byte("a,b"), time.Date(2026, 10, 14, 3, 0, 0, 0, time.UTC))
+		setSchedule("late", daily)
+		store.Put("myintegrator", "late/INPUT/extract.csv", []byte("a,b"), time.Date(2026, 10, 13, 3, 0, 0, 0, time.UTC))
+
+		for _, clientId := range []string{"collected", "late"} {
+			downloadDir, dirErr := ioutil.TempDir("", "monitor-collect")
+			Ω(dirErr).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(downloadDir)
+
+			clientController := Controller{Client: memory.Client{Store: store, IntegratorId: "myintegrator", ClientId: clientId}}
+			_, err = clientController.CollectDataFiles(downloadDir, ProcessedFolder)
+			Ω(err).ShouldNot(HaveOccurred())
+		}
+
+		report := ctrler.CheckClientArrival("collected", now)
+		Ω(report.Status).Should(Equal(ArrivalOk))
+		Ω(*report.LastUpload).Should(Equal(time.Date(2026, 10, 14, 3, 0, 0, 0, time.UTC)))
+
+		report = ctrler.CheckClientArrival("late", now)
+		Ω(report.Status).Should(Equal(ArrivalOverdue))
+		Ω(*report.LastUpload).Should(Equal(time.Date(2026, 10, 13, 3, 0, 0, 0, time.UTC)))
+	})
+
+	It("accepts a weekly file that arrived early", func() {
+		setSchedule("myclient", Schedule{Interval: WeeklyInterval, Weekday: time.Monday, Timezone: "UTC"})
+		store.Put("myintegrator", "myclient/INPUT/extract.csv", []byte("a,b"), time.Date(2026, 10, 11, 23, 0, 0, 0, time.UTC))
+
+		Ω(ctrler.CheckClientArrival("myclient", now).Status).Should(Equal(ArrivalOk))
+	})
+
+	Context("when the schedule is paused", func() {
+		BeforeEach(func() {
+			setSchedule("myclient", daily)
+			store.Put("myintegrator", "myclient/INPUT/extract.csv", []byte("a,b"), time.Date(2026, 10, 10, 3, 0, 0, 0, time.UTC))
+			clientController := Controller{Client: memory.Client{Store: store, IntegratorId: "myintegrator", ClientId: "myclient"}}
+			Ω(clientController.PauseSchedule(time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 11, 0, 0, 0, 0, time.UTC))).Should(Succeed())
+		})
+
+		It("suppresses alerts during the pause", func() {
+			report := ctrler.CheckClientArrival("myclient", now)
+			Ω(report.Status).Should(Equal(ArrivalPaused))
+			Ω(*report.PausedUntil).Should(Equal(time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC)))
+		})
+
+		It("expects files again once the pause is over", func() {
+			report := ctrler.CheckClientArrival("myclient", time.Date(2026, 10, 20, 12, 0, 0, 0, time.UTC))
+			Ω(report.Status).Should(Equal(ArrivalOverdue))
+			Ω(report.Expected.Start).Should(Equal(time.Date(2026, 10, 20, 2, 0, 0, 0, time.UTC)))
+		})
+	})
+
+	It("reports a client that could not be checked", func() {
+		setSchedule("myclient", daily)
+		store.FailOn("WalkFiles", errors.New("InternalError"))
+
+		report := ctrler.CheckClientArrival("myclient", now)
+		Ω(report.Status).Should(Equal(ArrivalCheckFailed))
+		Ω(report.Error).Should(Equal("InternalError"))
+	})
+
+	It("throws an error when the clients cannot be listed", func() {
+		store.FailOn("ListClients", errors.New("AccessDenied"))
+		_, err = ctrler.CheckArrivals(now)
+		Ω(err).Should(MatchError("AccessDenied"))
+	})
+})
@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Outbox is a local directory of data files whose upload failed, kept so that no data is lost while the
+// object store is unreachable. Each spooled file sits in its own directory named by the time it was spooled,
+// so that files are uploaded in the order they were spooled & keep their original names.
+type Outbox struct {
+	Dir string
+}
+
+// Spool copies a local file into the outbox, keeping its path under INPUT/
+func (outbox Outbox) Spool(file LocalDataFile, now time.Time) (spooledPath string, err error) {
+	if outbox.Dir == "" {
+		return "", errors.New("No outbox directory is set")
+	}
+	source, err := os.Open(file.Path)
+	if err != nil {
+		return
+	}
+	defer source.Close()
+
+	if err = os.MkdirAll(outbox.Dir, 0700); err != nil {
+		return
+	}
+	// files spooled within the same nanosecond each get the next free entry, keeping their order
+	entryTime := now.UnixNano()
+	entryDir := filepath.Join(outbox.Dir, fmt.Sprintf("%020d", entryTime))
+	for {
+		if err = os.Mkdir(entryDir, 0700); !os.IsExist(err) {
+			break
+		}
+		entryTime++
+		entryDir = filepath.Join(outbox.Dir, fmt.Sprintf("%020d", entryTime))
+	}
+	if err != nil {
+		return
+	}
+	spooledPath = filepath.Join(entryDir, filepath.FromSlash(file.RelativePath))
+	if err = os.MkdirAll(filepath.Dir(spooledPath), 0700); err != nil {
+		return
+	}
+
+	// copy under a hidden name first, so that a half-copied file is never flushed
+	tempFile, err := ioutil.TempFile(filepath.Dir(spooledPath), ".spool")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tempFile.Name())
+	if _, err = io.Copy(tempFile, source); err != nil {
+		tempFile.Close()
+		return
+	}
+	if err = tempFile.Close(); err != nil {
+		return
+	}
+	err = os.Rename(tempFile.Name(), spooledPath)
+	return
+}
+
+// Pending gives the spooled files, oldest first
+func (outbox Outbox) Pending() (files []LocalDataFile, err error) {
+	entries, err := ioutil.ReadDir(outbox.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		var entryFiles []LocalDataFile
+		selection := FileSelection{Recursive: true}
+		if entryFiles, err = selection.filesIn(filepath.Join(outbox.Dir, entry.Name())); err != nil {
+			return
+		}
+		files = append(files, entryFiles...)
+	}
+	return
+}
+
+// remove deletes a spooled file once it has been uploaded, along with its entry directory once that is empty
+func (outbox Outbox) remove(spooledPath string) error {
+	if err := os.Remove(spooledPath); err != nil {
+		return err
+	}
+	for dir := filepath.Dir(spooledPath); dir != filepath.Clean(outbox.Dir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// FlushOutbox uploads the spooled files in order, removing each once uploaded.
+// It stops at the first failure, so that files are never uploaded out of order.
+func (controller Controller) FlushOutbox(outbox Outbox) (uploaded []string, err error) {
+	pending, err := outbox.Pending()
+	if err != nil {
+		return
+	}
+	for _, spooled := range pending {
+		var fileName string
+		if fileName, err = controller.UploadDataFileAs(spooled.Path, spooled.RelativePath); err != nil {
+			err = errors.New("Unable to upload spooled file " + spooled.Path + ": " + err.Error())
+			return
+		}
+		if err = outbox.remove(spooled.Path); err != nil {
+			return
+		}
+		uploaded = append(uploaded, fileName)
+	}
+	return
+}
+
+// UploadDataFileOrSpool uploads a data file after the outbox's, spooling it when it or they fail, with the upload's error
+func (controller Controller) UploadDataFileOrSpool(file LocalDataFile, outbox Outbox, now time.Time) (fileName string, spooledPath string, err error) {
+	if _, err = os.Stat(file.Path); err != nil {
+		return
+	}
+
+	var uploadErr error
+	if _, uploadErr = controller.FlushOutbox(outbox); uploadErr == nil {
+		if fileName, uploadErr = controller.UploadDataFileAs(file.Path, file.RelativePath); uploadErr == nil {
+			return
+		}
+	}
+
+	if spooledPath, err = outbox.Spool(file, now); err != nil {
+		err = errors.New("Unable to spool file after failed upload (" + uploadErr.Error() + "): " + err.Error())
+		return
+	}
+	err = uploadErr
+	return
+}
+
+// UploadDataFilesOrSpool uploads or spools each file in turn, as UploadDataFileOrSpool.
+// Once a file has been spooled, the rest are spooled straight away rather than waiting on the store each time.
+func (controller Controller) UploadDataFilesOrSpool(files []LocalDataFile, outbox Outbox) (results []UploadResult) {
+	var spoolErr error
+	for _, file := range files {
+		result := UploadResult{File: file}
+		if spoolErr == nil {
+			result.FileName, result.SpooledPath, result.Err = controller.UploadDataFileOrSpool(file, outbox, time.Now())
+			if result.SpooledPath != "" {
+				spoolErr = result.Err
+			}
+		} else if result.SpooledPath, result.Err = outbox.Spool(file, time.Now()); result.Err == nil {
+			result.Err = spoolErr
+		}
+		results = append(results, result)
+	}
+	return
+}
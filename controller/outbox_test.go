@@ -0,0 +1,116 @@
+package controller_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/dhrapson/sched-load/controller"
+	"github.com/dhrapson/sched-load/iaas/memory"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Spooling uploads to an outbox", func() {
+
+	var (
+		store   *memory.Store
+		ctrler  Controller
+		outbox  Outbox
+		tempDir string
+		err     error
+		now     = time.Date(2026, 10, 14, 3, 0, 0, 0, time.UTC)
+	)
+
+	localFile := func(name string) LocalDataFile {
+		filePath := filepath.Join(tempDir, name)
+		Ω(ioutil.WriteFile(filePath, []byte(name), 0644)).Should(Succeed())
+		return LocalDataFile{Path: filePath, RelativePath: name}
+	}
+
+	BeforeEach(func() {
+		tempDir, err = ioutil.TempDir("", "controller-outbox")
+		Ω(err).ShouldNot(HaveOccurred())
+		store = memory.NewStore()
+		ctrler = Controller{Client: memory.Client{Store: store, IntegratorId: "myintegrator", ClientId: "myclient"}}
+		outbox = Outbox{Dir: filepath.Join(tempDir, "outbox")}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tempDir)
+	})
+
+	It("uploads straight away when the store is reachable", func() {
+		fileName, spooledPath, err := ctrler.UploadDataFileOrSpool(localFile("first.csv"), outbox, now)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(fileName).Should(Equal("INPUT/first.csv"))
+		Ω(spooledPath).Should(BeEmpty())
+		Ω(outbox.Pending()).Should(BeEmpty())
+	})
+
+	It("spools the file when the upload fails", func() {
+		store.FailOn("UploadFile", errors.New("RequestError: send request failed"))
+		_, spooledPath, err := ctrler.UploadDataFileOrSpool(localFile("first.csv"), outbox, now)
+		Ω(err).Should(MatchError("RequestError: send request failed"))
+		Ω(spooledPath).Should(HaveSuffix("first.csv"))
+		Ω(outbox.Pending()).Should(Equal([]LocalDataFile{{Path: spooledPath, RelativePath: "first.csv"}}))
+	})
+
+	It("drains the outbox in order once the store is reachable", func() {
+		store.FailOn("UploadFile", errors.New("RequestError: send request failed"))
+		ctrler.UploadDataFileOrSpool(localFile("first.csv"), outbox, now)
+		ctrler.UploadDataFileOrSpool(localFile("second.csv"), outbox, now.Add(time.Minute))
+		store.ClearFaults()
+
+		uploaded, err := ctrler.FlushOutbox(outbox)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(uploaded).Should(Equal([]string{"INPUT/first.csv", "INPUT/second.csv"}))
+		Ω(outbox.Pending()).Should(BeEmpty())
+		contents, found := store.Get("myintegrator", "myclient/INPUT/second.csv")
+		Ω(found).Should(BeTrue())
+		Ω(string(contents)).Should(Equal("second.csv"))
+	})
+
+	It("uploads spooled files before a new file on the next run", func() {
+		store.FailTimes("UploadFile", 1, errors.New("RequestError: send request failed"))
+		ctrler.UploadDataFileOrSpool(localFile("first.csv"), outbox, now)
+
+		_, spooledPath, err := ctrler.UploadDataFileOrSpool(localFile("second.csv"), outbox, now.Add(time.Minute))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(spooledPath).Should(BeEmpty())
+		Ω(store.Calls("UploadFile")).Should(Equal(3))
+		Ω(outbox.Pending()).Should(BeEmpty())
+	})
+
+	It("keeps files that still cannot be uploaded", func() {
+		store.FailOn("UploadFile", errors.New("RequestError: send request failed"))
+		ctrler.UploadDataFileOrSpool(localFile("first.csv"), outbox, now)
+
+		_, err = ctrler.FlushOutbox(outbox)
+		Ω(err).Should(HaveOccurred())
+		Ω(outbox.Pending()).Should(HaveLen(1))
+	})
+
+	It("does not spool a file that does not exist", func() {
+		_, spooledPath, err := ctrler.UploadDataFileOrSpool(LocalDataFile{Path: filepath.Join(tempDir, "missing.csv"), RelativePath: "missing.csv"}, outbox, now)
+		Ω(err).Should(HaveOccurred())
+		Ω(spooledPath).Should(BeEmpty())
+	})
+	It("keeps the sub-directory of a spooled file", func() {
+		Ω(os.Mkdir(filepath.Join(tempDir, "region"), 0755)).Should(Succeed())
+		file := localFile("region/first.csv")
+		store.FailOn("UploadFile", errors.New("RequestError: send request failed"))
+		results := ctrler.UploadDataFilesOrSpool([]LocalDataFile{file, localFile("second.csv")}, outbox)
+		Ω(results).Should(HaveLen(2))
+		Ω(results[1].SpooledPath).ShouldNot(BeEmpty())
+		Ω(store.Calls("UploadFile")).Should(Equal(1))
+		store.ClearFaults()
+
+		uploaded, err := ctrler.FlushOutbox(outbox)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(uploaded).Should(Equal([]string{"INPUT/region/first.csv", "INPUT/second.csv"}))
+		Ω(ioutil.ReadDir(outbox.Dir)).Should(BeEmpty())
+	})
+})
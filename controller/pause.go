@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"errors"
+	"time"
+)
+
+// Pause suspends a schedule's expected arrivals from one time until another, or until resumed when Until is zero
+type Pause struct {
+	From  time.Time
+	Until time.Time
+}
+
+func (pause Pause) IsSet() bool {
+	return !pause.From.IsZero()
+}
+
+// Covers tells whether no file is expected at the given time
+func (pause Pause) Covers(t time.Time) bool {
+	return pause.IsSet() && !t.Before(pause.From) && (pause.Until.IsZero() || t.Before(pause.Until))
+}
+
+func (pause Pause) String() string {
+	if !pause.IsSet() {
+		return ""
+	}
+	if pause.Until.IsZero() {
+		return "from " + pause.From.UTC().Format(time.RFC3339) + " until resumed"
+	}
+	return "from " + pause.From.UTC().Format(time.RFC3339) + " until " + pause.Until.UTC().Format(time.RFC3339)
+}
+
+// ParseLocalTime reads a date, e.g. 2026-11-01, or a date & time, e.g. 2026-11-01T06:00, in the schedule's timezone.
+// A full RFC 3339 time with an offset is also accepted.
+func (schedule Schedule) ParseLocalTime(text string) (t time.Time, err error) {
+	location, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02T15:04", time.RFC3339} {
+		if t, err = time.ParseInLocation(layout, text, location); err == nil {
+			return
+		}
+	}
+	err = errors.New("Time must be a date, e.g. 2026-11-01, or a date & time, e.g. 2026-11-01T06:00: " + text)
+	return
+}
+
+// PauseSchedule stops files being expected from now until the given time, or until resumed if until is zero.
+// The rest of the schedule is kept, so that it applies again once the pause is over.
+func (controller Controller) PauseSchedule(until time.Time, now time.Time) (err error) {
+	schedule, err := controller.GetSchedule()
+	if err != nil {
+		return
+	}
+	if !schedule.IsSet() {
+		return errors.New("No schedule is set to pause")
+	}
+	schedule.Pause = Pause{From: now, Until: until}
+	_, err = controller.writeSchedule(schedule)
+	return
+}
+
+func (controller Controller) ResumeSchedule() (wasPaused bool, err error) {
+	schedule, err := controller.GetSchedule()
+	if err != nil || !schedule.Pause.IsSet() {
+		return
+	}
+	wasPaused = true
+	schedule.Pause = Pause{}
+	_, err = controller.writeSchedule(schedule)
+	return
+}
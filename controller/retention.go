@@ -0,0 +1,209 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dhrapson/sched-load/iaas"
+)
+
+const (
+	// RetentionVersion is the version of the retention document written by this release
+	RetentionVersion = 1
+	// RetentionFileName is the well-known name of the retention document, relative to the client's upload area
+	RetentionFileName = "RETENTION.json"
+)
+
+// Retention holds a client's rules for how long data files are kept in each folder,
+// & is stored as a versioned JSON document at RetentionFileName
+type Retention struct {
+	Rules []RetentionRule
+}
+
+// RetentionRule deletes the files in a Folder, e.g. INPUT/, once they were last modified Days ago
+type RetentionRule struct {
+	Folder string `json:"folder"`
+	Days   int    `json:"days"`
+}
+
+type retentionDocument struct {
+	Version int             `json:"version"`
+	Rules   []RetentionRule `json:"rules"`
+}
+
+// RetentionReport describes how retention was applied: by the store's lifecycle rules, or by deleting the
+// expired files there & then
+type RetentionReport struct {
+	Lifecycle bool
+	Deleted   []iaas.FileInfo
+}
+
+// Set keeps the files in the folder for the given number of days, with no days removing the folder's rule
+func (retention *Retention) Set(folder string, days int) (err error) {
+	if err = validateRetentionFolder(folder); err != nil {
+		return
+	}
+	if days < 0 {
+		return errors.New("Retention days must not be negative")
+	}
+
+	var rules []RetentionRule
+	for _, rule := range retention.Rules {
+		if rule.Folder != folder {
+			rules = append(rules, rule)
+		}
+	}
+	if days > 0 {
+		rules = append(rules, RetentionRule{Folder: folder, Days: days})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Folder < rules[j].Folder })
+	retention.Rules = rules
+	return
+}
+
+func (retention Retention) IsSet() bool {
+	return len(retention.Rules) > 0
+}
+
+func (rule RetentionRule) String() string {
+	return "delete files in " + rule.Folder + " after " + strconv.Itoa(rule.Days) + " days"
+}
+
+func (retention Retention) MarshalJSON() ([]byte, error) {
+	document := retentionDocument{Version: RetentionVersion, Rules: retention.Rules}
+	if document.Rules == nil {
+		document.Rules = []RetentionRule{}
+	}
+	return json.Marshal(document)
+}
+
+func (retention *Retention) UnmarshalJSON(data []byte) (err error) {
+	var document retentionDocument
+	if err = json.Unmarshal(data, &document); err != nil {
+		return
+	}
+	if document.Version < 1 || document.Version > RetentionVersion {
+		return fmt.Errorf("Unsupported retention document version %d, this release supports up to version %d", document.Version, RetentionVersion)
+	}
+
+	var parsed Retention
+	for _, rule := range document.Rules {
+		if err = parsed.Set(rule.Folder, rule.Days); err != nil {
+			return
+		}
+	}
+	*retention = parsed
+	return
+}
+
+func (controller Controller) GetRetention() (retention Retention, err error) {
+	var found bool
+	if found, err = controller.remoteFileExists(RetentionFileName); err != nil || !found {
+		return
+	}
+
+	contents, err := controller.readRemoteFile(RetentionFileName)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(contents, &retention)
+	return
+}
+
+// SetRetention stores the client's retention rules, which take effect once applied
+func (controller Controller) SetRetention(retention Retention) (err error) {
+	if !retention.IsSet() {
+		_, err = controller.Client.DeleteFile(RetentionFileName)
+		return
+	}
+
+	contents, err := json.MarshalIndent(retention, "", "  ")
+	if err != nil {
+		return
+	}
+	_, err = controller.writeRemoteFile(RetentionFileName, append(contents, '\n'))
+	return
+}
+
+// ApplyRetention puts the client's retention rules in place as lifecycle rules of the store, so that the store
+// expires files itself. Where the store has no lifecycle support, or when sweeping is asked for, the files
+// that have expired are deleted now instead, which has to be repeated, e.g. daily, to keep them expiring.
+func (controller Controller) ApplyRetention(sweep bool, now time.Time) (report RetentionReport, err error) {
+	retention, err := controller.GetRetention()
+	if err != nil {
+		return
+	}
+
+	if !sweep {
+		var rules []iaas.LifecycleRule
+		for _, rule := range retention.Rules {
+			rules = append(rules, iaas.LifecycleRule{Prefix: rule.Folder, Days: rule.Days})
+		}
+		if report.Lifecycle, err = controller.Client.PutLifecycleRules(rules); err != nil || report.Lifecycle {
+			return
+		}
+	}
+
+	for _, rule := range retention.Rules {
+		cutoff := now.Add(-time.Duration(rule.Days) * 24 * time.Hour)
+		var expired []iaas.FileInfo
+		var names []string
+		err = controller.Client.WalkFiles(rule.Folder, func(file iaas.FileInfo) error {
+			if file.LastModified.Before(cutoff) {
+				expired = append(expired, file)
+				names = append(names, file.Name)
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+		if len(names) == 0 {
+			continue
+		}
+
+		var deleted []string
+		deleted, err = controller.Client.DeleteFiles(names)
+
+		wasDeleted := map[string]bool{}
+		for _, name := range deleted {
+			wasDeleted[name] = true
+		}
+		for _, file := range expired {
+			if wasDeleted[file.Name] {
+				report.Deleted = append(report.Deleted, file)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// ParseRetentionFolder gives the folder that a retention rule applies to, from its name, input, processed or archive
+func ParseRetentionFolder(name string) (folder string, err error) {
+	if name == "" {
+		return "", errors.New("You must specify the folder, input, processed or archive")
+	}
+	if strings.ToLower(name) == "input" {
+		return InputFolder, nil
+	}
+	if folder, err = ParseFolder(name); err != nil {
+		err = errors.New("Unknown folder " + name + ", expected input, processed or archive")
+	}
+	return
+}
+
+func validateRetentionFolder(folder string) error {
+	switch folder {
+	case InputFolder, ProcessedFolder, ArchiveFolder:
+		return nil
+	}
+	return errors.New("Unknown folder " + folder + ", expected " + InputFolder + ", " + ProcessedFolder + " or " + ArchiveFolder)
+}
@@ -0,0 +1,450 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dhrapson/sched-load/iaas"
+)
+
+const (
+	NoInterval      = "NONE"
+	DailyInterval   = "DAILY"
+	WeeklyInterval  = "WEEKLY"
+	MonthlyInterval = "MONTHLY"
+	CronInterval    = "CRON"
+)
+
+const (
+	// ScheduleVersion is the version of the schedule document written by this release
+	ScheduleVersion = 1
+	// ScheduleFileName is the well-known name of the schedule document, relative to the client's upload area
+	ScheduleFileName = "SCHEDULE.json"
+)
+
+// Schedule describes when the object store owner should expect data files from a client,
+// & is stored as a versioned JSON document at ScheduleFileName
+type Schedule struct {
+	Interval string
+	// Weekday is the day of the week files arrive on, for WEEKLY schedules
+	Weekday time.Weekday
+	// DayOfMonth is the day of the month files arrive on, for MONTHLY schedules.
+	// In months without that day, files are expected on the last day of the month.
+	DayOfMonth int
+	// Cron is a standard 5 field cron expression, for CRON schedules
+	Cron string
+	// Timezone is the IANA name of the timezone the source system runs in, UTC if empty
+	Timezone string
+	// FilePattern is a shell pattern that expected file names match, any file if empty
+	FilePattern string
+	// Window is the local time of day that files are expected to arrive, all day if empty
+	Window ArrivalWindow
+	// GracePeriod is how late a file can be before it is reported as missing
+	GracePeriod time.Duration
+	// OwnerContact is who to contact when a file is missing
+	OwnerContact string
+	// Pause suspends expected arrivals, e.g. while the source system is upgraded
+	Pause Pause
+}
+
+// ArrivalWindow is a time of day range, as offsets from midnight. An End before Start spans midnight.
+type ArrivalWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+type scheduleDocument struct {
+	Version      int                    `json:"version"`
+	Interval     string                 `json:"interval"`
+	DayOfWeek    string                 `json:"day_of_week,omitempty"`
+	DayOfMonth   int                    `json:"day_of_month,omitempty"`
+	Cron         string                 `json:"cron,omitempty"`
+	Timezone     string                 `json:"timezone"`
+	FilePattern  string                 `json:"file_pattern,omitempty"`
+	Window       *arrivalWindowDocument `json:"arrival_window,omitempty"`
+	GracePeriod  string                 `json:"grace_period,omitempty"`
+	OwnerContact string                 `json:"owner_contact,omitempty"`
+	Pause        *pauseDocument         `json:"pause,omitempty"`
+}
+
+type arrivalWindowDocument struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+type pauseDocument struct {
+	From  time.Time  `json:"from"`
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// ParseSchedule reads the form given by Schedule.String, e.g. "DAILY", "WEEKLY mon", "MONTHLY 1" or "CRON 0 6 * * 1-5"
+func ParseSchedule(text string) (schedule Schedule, err error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		err = errors.New("Empty schedule")
+		return
+	}
+	schedule.Interval = strings.ToUpper(fields[0])
+	arguments := fields[1:]
+
+	switch schedule.Interval {
+	case NoInterval, DailyInterval:
+		if len(arguments) != 0 {
+			err = errors.New("Unexpected arguments for " + schedule.Interval + " schedule: " + strings.Join(arguments, " "))
+		}
+	case WeeklyInterval:
+		if len(arguments) != 1 {
+			err = errors.New("A weekly schedule needs a day of the week")
+			return
+		}
+		schedule.Weekday, err = parseWeekday(arguments[0])
+	case MonthlyInterval:
+		if len(arguments) != 1 {
+			err = errors.New("A monthly schedule needs a day of the month")
+			return
+		}
+		schedule.DayOfMonth, err = strconv.Atoi(arguments[0])
+		if err != nil || schedule.DayOfMonth < 1 || schedule.DayOfMonth > 31 {
+			err = errors.New("Invalid day of the month: " + arguments[0])
+		}
+	case CronInterval:
+		schedule.Cron = strings.Join(arguments, " ")
+		_, err = parseCron(schedule.Cron)
+	default:
+		err = errors.New("Unknown schedule interval: " + fields[0])
+	}
+	return
+}
+
+// ParseArrivalWindow reads a window of the form HH:MM-HH:MM, e.g. 02:00-04:00
+func ParseArrivalWindow(text string) (window ArrivalWindow, err error) {
+	bounds := strings.Split(text, "-")
+	if len(bounds) != 2 {
+		err = errors.New("Arrival window must be of the form HH:MM-HH:MM: " + text)
+		return
+	}
+	if window.Start, err = parseTimeOfDay(bounds[0]); err != nil {
+		return
+	}
+	if window.End, err = parseTimeOfDay(bounds[1]); err != nil {
+		return
+	}
+	if window.Start == window.End {
+		err = errors.New("Arrival window must not be empty: " + text)
+	}
+	return
+}
+
+func (schedule Schedule) String() string {
+	switch schedule.Interval {
+	case WeeklyInterval:
+		return schedule.Interval + " " + strings.ToLower(schedule.Weekday.String()[:3])
+	case MonthlyInterval:
+		return schedule.Interval + " " + strconv.Itoa(schedule.DayOfMonth)
+	case CronInterval:
+		return schedule.Interval + " " + schedule.Cron
+	case "":
+		return NoInterval
+	default:
+		return schedule.Interval
+	}
+}
+
+func (schedule Schedule) IsSet() bool {
+	return schedule.Interval != "" && schedule.Interval != NoInterval
+}
+
+// Validate checks every part of the schedule, as ParseSchedule only checks the interval
+func (schedule Schedule) Validate() (err error) {
+	if _, err = ParseSchedule(schedule.String()); err != nil {
+		return
+	}
+	if _, err = time.LoadLocation(schedule.Timezone); err != nil {
+		return errors.New("Unknown timezone: " + schedule.Timezone)
+	}
+	if _, err = path.Match(schedule.FilePattern, ""); err != nil {
+		return errors.New("Invalid file pattern: " + schedule.FilePattern)
+	}
+	if schedule.GracePeriod < 0 {
+		return errors.New("Grace period must not be negative")
+	}
+	if !schedule.Pause.Until.IsZero() && !schedule.Pause.Until.After(schedule.Pause.From) {
+		return errors.New("A pause must end after it starts")
+	}
+	return
+}
+
+func (window ArrivalWindow) IsSet() bool {
+	return window != ArrivalWindow{}
+}
+
+func (window ArrivalWindow) String() string {
+	if !window.IsSet() {
+		return ""
+	}
+	return formatTimeOfDay(window.Start) + "-" + formatTimeOfDay(window.End)
+}
+
+func (schedule Schedule) MarshalJSON() ([]byte, error) {
+	document := scheduleDocument{
+		Version:      ScheduleVersion,
+		Interval:     schedule.Interval,
+		DayOfMonth:   schedule.DayOfMonth,
+		Cron:         schedule.Cron,
+		Timezone:     schedule.Timezone,
+		FilePattern:  schedule.FilePattern,
+		OwnerContact: schedule.OwnerContact,
+	}
+	if document.Timezone == "" {
+		document.Timezone = "UTC"
+	}
+	if schedule.Interval == WeeklyInterval {
+		document.DayOfWeek = strings.ToLower(schedule.Weekday.String()[:3])
+	}
+	if schedule.Window.IsSet() {
+		document.Window = &arrivalWindowDocument{
+			Start: formatTimeOfDay(schedule.Window.Start),
+			End:   formatTimeOfDay(schedule.Window.End),
+		}
+	}
+	if schedule.GracePeriod != 0 {
+		document.GracePeriod = schedule.GracePeriod.String()
+	}
+	if schedule.Pause.IsSet() {
+		document.Pause = &pauseDocument{From: schedule.Pause.From.UTC()}
+		if !schedule.Pause.Until.IsZero() {
+			until := schedule.Pause.Until.UTC()
+			document.Pause.Until = &until
+		}
+	}
+	return json.Marshal(document)
+}
+
+func (schedule *Schedule) UnmarshalJSON(data []byte) (err error) {
+	var document scheduleDocument
+	if err = json.Unmarshal(data, &document); err != nil {
+		return
+	}
+	if document.Version < 1 || document.Version > ScheduleVersion {
+		return fmt.Errorf("Unsupported schedule document version %d, this release supports up to version %d", document.Version, ScheduleVersion)
+	}
+
+	parsed := Schedule{
+		Interval:     strings.ToUpper(document.Interval),
+		DayOfMonth:   document.DayOfMonth,
+		Cron:         document.Cron,
+		Timezone:     document.Timezone,
+		FilePattern:  document.FilePattern,
+		OwnerContact: document.OwnerContact,
+	}
+	if parsed.Interval == WeeklyInterval {
+		if parsed.Weekday, err = parseWeekday(document.DayOfWeek); err != nil {
+			return
+		}
+	}
+	if document.Window != nil {
+		if parsed.Window, err = ParseArrivalWindow(document.Window.Start + "-" + document.Window.End); err != nil {
+			return
+		}
+	}
+	if document.GracePeriod != "" {
+		if parsed.GracePeriod, err = time.ParseDuration(document.GracePeriod); err != nil {
+			return
+		}
+	}
+	if document.Pause != nil {
+		parsed.Pause.From = document.Pause.From
+		if document.Pause.Until != nil {
+			parsed.Pause.Until = *document.Pause.Until
+		}
+	}
+	if err = parsed.Validate(); err != nil {
+		return
+	}
+	*schedule = parsed
+	return
+}
+
+func (controller Controller) RemoveSchedule() (previouslySet bool, err error) {
+	return controller.removeScheduleFiles(true)
+}
+
+// removeScheduleFiles deletes the schedules from before the schedule document, & the document itself if asked to
+func (controller Controller) removeScheduleFiles(document bool) (previouslySet bool, err error) {
+	fileNames := legacyScheduleFiles
+	if document {
+		fileNames = append([]string{ScheduleFileName}, fileNames...)
+	}
+
+	for _, fileName := range fileNames {
+		var removed bool
+		if removed, err = controller.Client.DeleteFile(fileName); err != nil {
+			return
+		}
+		previouslySet = previouslySet || removed
+	}
+	return
+}
+
+// SetSchedule replaces the client's schedule, keeping any pause of the existing schedule unless the new one sets its own,
+// as only ResumeSchedule should start alerting again
+func (controller Controller) SetSchedule(schedule Schedule) (result bool, err error) {
+	if !schedule.IsSet() {
+		err = errors.New("Use RemoveSchedule to remove a schedule")
+		return
+	}
+	if !schedule.Pause.IsSet() {
+		var existing Schedule
+		if existing, err = controller.GetSchedule(); err != nil {
+			return
+		}
+		schedule.Pause = existing.Pause
+	}
+	return controller.writeSchedule(schedule)
+}
+
+func (controller Controller) writeSchedule(schedule Schedule) (result bool, err error) {
+	result = false
+	if err = schedule.Validate(); err != nil {
+		return
+	}
+
+	contents, err := json.MarshalIndent(schedule, "", "  ")
+	if err != nil {
+		return
+	}
+
+	var fileName string
+	if fileName, err = controller.writeRemoteFile(ScheduleFileName, append(contents, '\n')); err != nil {
+		return
+	}
+	result = (fileName == ScheduleFileName)
+
+	// a client has one schedule, so any from before the schedule document go once it is replaced, leaving the
+	// existing schedule in place should the upload fail
+	_, err = controller.removeScheduleFiles(false)
+	return
+}
+
+func (controller Controller) GetSchedule() (result Schedule, err error) {
+	var found bool
+	if found, err = controller.remoteFileExists(ScheduleFileName); err != nil {
+		return
+	}
+	if found {
+		var contents []byte
+		if contents, err = controller.readRemoteFile(ScheduleFileName); err != nil {
+			return
+		}
+		err = json.Unmarshal(contents, &result)
+		return
+	}
+
+	result = Schedule{Interval: NoInterval}
+	for _, fileName := range legacyScheduleFiles {
+		if found, err = controller.remoteFileExists(fileName); err != nil || found {
+			if found {
+				result, err = controller.readLegacySchedule(fileName)
+			}
+			return
+		}
+	}
+	return
+}
+
+// remoteFileExists checks for a single known file, rather than listing every file of the client
+func (controller Controller) remoteFileExists(fileName string) (exists bool, err error) {
+	if _, err = controller.Client.StatFile(fileName); iaas.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// readLegacySchedule reads schedules from before the schedule document, which were named <INTERVAL>_SCHEDULE
+func (controller Controller) readLegacySchedule(fileName string) (schedule Schedule, err error) {
+	interval := strings.TrimSuffix(fileName, "_SCHEDULE")
+	if interval == DailyInterval {
+		// the original daily schedule files were empty
+		schedule = Schedule{Interval: DailyInterval}
+		return
+	}
+	contents, err := controller.readRemoteFile(fileName)
+	if err != nil {
+		return
+	}
+	schedule, err = ParseSchedule(string(contents))
+	return
+}
+
+// writeRemoteFile uploads a control document, e.g. the schedule, as it is, as the integrator must be able to read it
+// without the client's keys
+func (controller Controller) writeRemoteFile(fileName string, contents []byte) (name string, err error) {
+	tempFile, err := ioutil.TempFile("", "write-remote-file")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tempFile.Name())
+	_, err = tempFile.Write(contents)
+	tempFile.Close()
+	if err != nil {
+		return
+	}
+	name, _, err = controller.Client.WithoutEncoding().UploadFile(tempFile.Name(), fileName)
+	return
+}
+
+func (controller Controller) readRemoteFile(fileName string) (contents []byte, err error) {
+	tempDir, err := ioutil.TempDir("", "read-remote-file")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	localPath, err := controller.Client.WithoutEncoding().GetFile(fileName, tempDir)
+	if err != nil {
+		return
+	}
+	contents, err = ioutil.ReadFile(localPath)
+	return
+}
+
+func parseWeekday(day string) (weekday time.Weekday, err error) {
+	lowerDay := strings.ToLower(day)
+	for candidate := time.Sunday; candidate <= time.Saturday; candidate++ {
+		name := strings.ToLower(candidate.String())
+		if lowerDay == name || lowerDay == name[:3] {
+			return candidate, nil
+		}
+	}
+	err = errors.New("Invalid day of the week: " + day)
+	return
+}
+
+func parseTimeOfDay(text string) (offset time.Duration, err error) {
+	parsed, err := time.Parse("15:04", strings.TrimSpace(text))
+	if err != nil {
+		err = errors.New("Invalid time of day, expected HH:MM: " + text)
+		return
+	}
+	offset = time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute
+	return
+}
+
+func formatTimeOfDay(offset time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(offset.Hours()), int(offset.Minutes())%60)
+}
+
+// legacyScheduleFiles are the schedules from before the schedule document, named <INTERVAL>_SCHEDULE
+var legacyScheduleFiles = []string{
+	DailyInterval + "_SCHEDULE",
+	WeeklyInterval + "_SCHEDULE",
+	MonthlyInterval + "_SCHEDULE",
+	CronInterval + "_SCHEDULE",
+}
@@ -0,0 +1,168 @@
+package controller_test
+
+import (
+	"encoding/json"
+	"time"
+
+	. "github.com/dhrapson/sched-load/controller"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Schedules", func() {
+
+	DescribeTable("parsing valid schedules",
+		func(text string, expected Schedule, canonical string) {
+			schedule, err := ParseSchedule(text)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(schedule).Should(Equal(expected))
+			Ω(schedule.String()).Should(Equal(canonical))
+		},
+		Entry("none", "NONE", Schedule{Interval: NoInterval}, "NONE"),
+		Entry("daily", "daily", Schedule{Interval: DailyInterval}, "DAILY"),
+		Entry("weekly by short name", "WEEKLY mon", Schedule{Interval: WeeklyInterval, Weekday: time.Monday}, "WEEKLY mon"),
+		Entry("weekly by full name", "weekly Saturday", Schedule{Interval: WeeklyInterval, Weekday: time.Saturday}, "WEEKLY sat"),
+		Entry("monthly", "MONTHLY 31", Schedule{Interval: MonthlyInterval, DayOfMonth: 31}, "MONTHLY 31"),
+		Entry("cron", "CRON 0 6 * * 1-5", Schedule{Interval: CronInterval, Cron: "0 6 * * 1-5"}, "CRON 0 6 * * 1-5"),
+		Entry("cron with names & steps", "CRON */15 0-6/2 1,15 jan-jun mon-fri", Schedule{Interval: CronInterval, Cron: "*/15 0-6/2 1,15 jan-jun mon-fri"}, "CRON */15 0-6/2 1,15 jan-jun mon-fri"),
+	)
+
+	DescribeTable("rejecting invalid schedules",
+		func(text string) {
+			_, err := ParseSchedule(text)
+			Ω(err).Should(HaveOccurred())
+		},
+		Entry("empty", ""),
+		Entry("unknown interval", "HOURLY"),
+		Entry("daily with arguments", "DAILY mon"),
+		Entry("weekly without a day", "WEEKLY"),
+		Entry("weekly with a bad day", "WEEKLY someday"),
+		Entry("monthly with day 0", "MONTHLY 0"),
+		Entry("monthly with day 32", "MONTHLY 32"),
+		Entry("monthly with a name", "MONTHLY first"),
+		Entry("cron with too few fields", "CRON 0 6 * *"),
+		Entry("cron with an out of range hour", "CRON 0 24 * * *"),
+		Entry("cron with a backwards range", "CRON 0 6 * * 5-1"),
+		Entry("cron with a bad step", "CRON */0 6 * * *"),
+	)
+
+	It("treats an empty schedule as not set", func() {
+		Ω(Schedule{}.IsSet()).Should(BeFalse())
+		Ω(Schedule{}.String()).Should(Equal("NONE"))
+		Ω(Schedule{Interval: NoInterval}.IsSet()).Should(BeFalse())
+		Ω(Schedule{Interval: DailyInterval}.IsSet()).Should(BeTrue())
+	})
+
+	Describe("arrival windows", func() {
+		It("parses a window within a day", func() {
+			window, err := ParseArrivalWindow("02:00-04:30")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(window).Should(Equal(ArrivalWindow{Start: 2 * time.Hour, End: 4*time.Hour + 30*time.Minute}))
+			Ω(window.String()).Should(Equal("02:00-04:30"))
+		})
+
+		It("parses a window spanning midnight", func() {
+			window, err := ParseArrivalWindow("23:00-01:00")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(window).Should(Equal(ArrivalWindow{Start: 23 * time.Hour, End: time.Hour}))
+		})
+
+		It("rejects malformed windows", func() {
+			for _, text := range []string{"02:00", "2am-4am", "02:00-25:00", "03:00-03:00"} {
+				_, err := ParseArrivalWindow(text)
+				Ω(err).Should(HaveOccurred(), text)
+			}
+		})
+	})
+
+	Describe("the schedule document", func() {
+		It("is versioned JSON with every detail", func() {
+			schedule, err := ParseSchedule("WEEKLY mon")
+			Ω(err).ShouldNot(HaveOccurred())
+			schedule.Window, err = ParseArrivalWindow("02:00-04:00")
+			Ω(err).ShouldNot(HaveOccurred())
+			schedule.GracePeriod = 2 * time.Hour
+			schedule.OwnerContact = "ops@example.com"
+
+			document, err := json.Marshal(schedule)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(document).Should(MatchJSON(`{
+				"version": 1,
+				"interval": "WEEKLY",
+				"day_of_week": "mon",
+				"timezone": "UTC",
+				"arrival_window": {"start": "02:00", "end": "04:00"},
+				"grace_period": "2h0m0s",
+				"owner_contact": "ops@example.com"
+			}`))
+		})
+
+		It("rejects invalid documents", func() {
+			var schedule Schedule
+			Ω(json.Unmarshal([]byte(`{"version": 1, "interval": "WEEKLY"}`), &schedule)).ShouldNot(Succeed())
+			Ω(json.Unmarshal([]byte(`{"version": 1, "interval": "DAILY", "timezone": "Mars/Olympus"}`), &schedule)).ShouldNot(Succeed())
+			Ω(json.Unmarshal([]byte(`{"interval": "DAILY"}`), &schedule)).ShouldNot(Succeed())
+		})
+
+		It("validates the details", func() {
+			Ω(Schedule{Interval: DailyInterval, Timezone: "Europe/London"}.Validate()).Should(Succeed())
+			Ω(Schedule{Interval: DailyInterval, Timezone: "Nowhere/Special"}.Validate()).ShouldNot(Succeed())
+			Ω(Schedule{Interval: DailyInterval, FilePattern: "[a-"}.Validate()).ShouldNot(Succeed())
+			Ω(Schedule{Interval: DailyInterval, GracePeriod: -time.Minute}.Validate()).ShouldNot(Succeed())
+			from := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+			Ω(Schedule{Interval: DailyInterval, Pause: Pause{From: from, Until: from}}.Validate()).ShouldNot(Succeed())
+		})
+
+		It("stores a pause alongside the schedule", func() {
+			from := time.Date(2026, 10, 17, 9, 30, 0, 0, time.UTC)
+			schedule := Schedule{Interval: DailyInterval, Timezone: "UTC", Pause: Pause{From: from, Until: from.AddDate(0, 0, 15)}}
+
+			document, err := json.Marshal(schedule)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(document).Should(MatchJSON(`{
+				"version": 1,
+				"interval": "DAILY",
+				"timezone": "UTC",
+				"pause": {"from": "2026-10-17T09:30:00Z", "until": "2026-11-01T09:30:00Z"}
+			}`))
+
+			var parsed Schedule
+			Ω(json.Unmarshal(document, &parsed)).Should(Succeed())
+			Ω(parsed.Pause.From.Equal(schedule.Pause.From)).Should(BeTrue())
+			Ω(parsed.Pause.Until.Equal(schedule.Pause.Until)).Should(BeTrue())
+		})
+	})
+
+	Describe("pauses", func() {
+		from := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+
+		It("covers the time from the start until the end", func() {
+			pause := Pause{From: from, Until: from.AddDate(0, 0, 1)}
+			Ω(pause.Covers(from.Add(-time.Second))).Should(BeFalse())
+			Ω(pause.Covers(from)).Should(BeTrue())
+			Ω(pause.Covers(from.AddDate(0, 0, 1))).Should(BeFalse())
+		})
+
+		It("covers all later times until resumed", func() {
+			pause := Pause{From: from}
+			Ω(pause.Covers(from.AddDate(10, 0, 0))).Should(BeTrue())
+			Ω(pause.String()).Should(Equal("from 2026-10-17T00:00:00Z until resumed"))
+			Ω(Pause{}.Covers(from)).Should(BeFalse())
+		})
+
+		It("reads times in the schedule's timezone", func() {
+			schedule := Schedule{Interval: DailyInterval, Timezone: "Europe/London"}
+			until, err := schedule.ParseLocalTime("2026-11-01")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(until).Should(BeTemporally("==", time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)))
+
+			until, err = schedule.ParseLocalTime("2026-10-20T06:00")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(until).Should(BeTemporally("==", time.Date(2026, 10, 20, 5, 0, 0, 0, time.UTC)))
+
+			_, err = schedule.ParseLocalTime("next week")
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+})
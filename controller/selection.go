@@ -0,0 +1,184 @@
+package controller
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LocalDataFile is a local file to upload, with its slash-separated path under INPUT/
+type LocalDataFile struct {
+	Path         string
+	RelativePath string
+}
+
+// FileSelection picks the local files to upload from a file, a directory or a glob pattern
+type FileSelection struct {
+	Pattern string
+	// Recursive includes the files in sub-directories of any directory selected
+	Recursive bool
+	// Include & Exclude are glob patterns matched against a file's name or its path relative to its directory
+	Include []string
+	Exclude []string
+}
+
+// Files gives the selected files, sorted by path. Hidden files & directories are skipped.
+func (selection FileSelection) Files() (files []LocalDataFile, err error) {
+	if err = selection.validate(); err != nil {
+		return
+	}
+
+	// files matching a glob keep their paths below where the glob's wildcards start, so that matches of the same
+	// name in different directories, e.g. a/x.csv & b/x.csv for exports/*, do not overwrite each other
+	matches := []string{selection.Pattern}
+	root := ""
+	if hasMeta(selection.Pattern) {
+		root = globRoot(selection.Pattern)
+		var globbed []string
+		if globbed, err = filepath.Glob(selection.Pattern); err != nil {
+			return
+		}
+		// unlike a shell, Glob matches hidden files with a wildcard
+		matches = nil
+		for _, match := range globbed {
+			if !strings.HasPrefix(filepath.Base(match), ".") {
+				matches = append(matches, match)
+			}
+		}
+	}
+
+	for _, match := range matches {
+		var info os.FileInfo
+		if info, err = os.Stat(match); err != nil {
+			return
+		}
+		base := ""
+		if root != "" {
+			if base, err = filepath.Rel(root, match); err != nil {
+				return
+			}
+			base = filepath.ToSlash(base)
+		}
+		if !info.IsDir() {
+			if base == "" {
+				base = filepath.Base(match)
+			}
+			if selection.selects(filepath.Base(match)) {
+				files = append(files, LocalDataFile{Path: match, RelativePath: base})
+			}
+			continue
+		}
+		var dirFiles []LocalDataFile
+		if dirFiles, err = selection.filesIn(match); err != nil {
+			return
+		}
+		for _, file := range dirFiles {
+			if base != "" {
+				file.RelativePath = base + "/" + file.RelativePath
+			}
+			files = append(files, file)
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, errors.New("No files match " + selection.Pattern)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return
+}
+
+func (selection FileSelection) validate() error {
+	if selection.Pattern == "" {
+		return errors.New("You must specify a file, directory or glob pattern")
+	}
+	for _, pattern := range append(append([]string{}, selection.Include...), selection.Exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return errors.New("Invalid pattern '" + pattern + "': " + err.Error())
+		}
+	}
+	return nil
+}
+
+// filesIn gives the files in a directory, relative to it
+func (selection FileSelection) filesIn(dir string) (files []LocalDataFile, err error) {
+	err = filepath.Walk(dir, func(filePath string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if filePath == dir {
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), ".") || (info.IsDir() && !selection.Recursive) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		relativePath, relErr := filepath.Rel(dir, filePath)
+		if relErr != nil {
+			return relErr
+		}
+		relativePath = filepath.ToSlash(relativePath)
+		if selection.selects(relativePath) {
+			files = append(files, LocalDataFile{Path: filePath, RelativePath: relativePath})
+		}
+		return nil
+	})
+	return
+}
+
+func (selection FileSelection) selects(relativePath string) bool {
+	if len(selection.Include) > 0 && !matchesAny(selection.Include, relativePath) {
+		return false
+	}
+	return !matchesAny(selection.Exclude, relativePath)
+}
+
+func matchesAny(patterns []string, relativePath string) bool {
+	name := relativePath[strings.LastIndex(relativePath, "/")+1:]
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+		if matched, _ := filepath.Match(pattern, relativePath); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// globRoot gives the directory of a glob pattern before its first wildcard, e.g. exports for exports/*/x.csv
+func globRoot(pattern string) string {
+	root := filepath.Dir(pattern)
+	for hasMeta(root) {
+		root = filepath.Dir(root)
+	}
+	return root
+}
+
+func hasMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[`)
+}
+
+// UploadResult is the outcome of uploading one of several data files
+type UploadResult struct {
+	File        LocalDataFile
+	FileName    string
+	SpooledPath string
+	Err         error
+}
+
+// UploadDataFiles uploads each file in turn, carrying on past failures so that one bad file does not hold up the rest
+func (controller Controller) UploadDataFiles(files []LocalDataFile) (results []UploadResult) {
+	for _, file := range files {
+		result := UploadResult{File: file}
+		result.FileName, result.Err = controller.UploadDataFileAs(file.Path, file.RelativePath)
+		results = append(results, result)
+	}
+	return
+}
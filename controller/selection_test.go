@@ -0,0 +1,100 @@
+package controller_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/dhrapson/sched-load/controller"
+	"github.com/dhrapson/sched-load/iaas/memory"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Selecting several files to upload", func() {
+
+	var (
+		tempDir string
+		err     error
+	)
+
+	relativePaths := func(selection FileSelection) (paths []string) {
+		files, selectErr := selection.Files()
+		Ω(selectErr).ShouldNot(HaveOccurred())
+		for _, file := range files {
+			paths = append(paths, file.RelativePath)
+		}
+		return
+	}
+
+	BeforeEach(func() {
+		tempDir, err = ioutil.TempDir("", "controller-selection")
+		Ω(err).ShouldNot(HaveOccurred())
+		for _, name := range []string{"a.csv", "b.csv", "notes.txt", ".hidden.csv", "sub/c.csv", "sub/deeper/d.csv", "sub/e.tmp"} {
+			filePath := filepath.Join(tempDir, filepath.FromSlash(name))
+			Ω(os.MkdirAll(filepath.Dir(filePath), 0755)).Should(Succeed())
+			Ω(ioutil.WriteFile(filePath, []byte(name), 0644)).Should(Succeed())
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tempDir)
+	})
+
+	It("selects a single file", func() {
+		Ω(relativePaths(FileSelection{Pattern: filepath.Join(tempDir, "a.csv")})).Should(Equal([]string{"a.csv"}))
+	})
+
+	It("selects the files matching a glob", func() {
+		Ω(relativePaths(FileSelection{Pattern: filepath.Join(tempDir, "*.csv")})).Should(Equal([]string{"a.csv", "b.csv"}))
+	})
+
+	It("keeps the paths of glob matches below the glob's wildcards", func() {
+		Ω(os.MkdirAll(filepath.Join(tempDir, "other"), 0755)).Should(Succeed())
+		Ω(ioutil.WriteFile(filepath.Join(tempDir, "other", "c.csv"), []byte("c"), 0644)).Should(Succeed())
+
+		Ω(relativePaths(FileSelection{Pattern: filepath.Join(tempDir, "*", "c.csv")})).Should(Equal([]string{"other/c.csv", "sub/c.csv"}))
+		Ω(relativePaths(FileSelection{Pattern: filepath.Join(tempDir, "*"), Include: []string{"c.csv"}})).Should(Equal([]string{"other/c.csv", "sub/c.csv"}))
+	})
+
+	It("selects the top level of a directory", func() {
+		Ω(relativePaths(FileSelection{Pattern: tempDir})).Should(Equal([]string{"a.csv", "b.csv", "notes.txt"}))
+	})
+
+	It("selects a directory recursively, keeping relative paths", func() {
+		Ω(relativePaths(FileSelection{Pattern: tempDir, Recursive: true})).Should(Equal([]string{
+			"a.csv", "b.csv", "notes.txt", "sub/c.csv", "sub/deeper/d.csv", "sub/e.tmp",
+		}))
+	})
+
+	It("applies include & exclude patterns", func() {
+		selection := FileSelection{Pattern: tempDir, Recursive: true, Include: []string{"*.csv"}, Exclude: []string{"sub/deeper/*", "b.csv"}}
+		Ω(relativePaths(selection)).Should(Equal([]string{"a.csv", "sub/c.csv"}))
+	})
+
+	It("throws an error when nothing matches", func() {
+		_, err = FileSelection{Pattern: filepath.Join(tempDir, "*.json")}.Files()
+		Ω(err).Should(MatchError("No files match " + filepath.Join(tempDir, "*.json")))
+	})
+
+	It("throws an error for an invalid pattern", func() {
+		_, err = FileSelection{Pattern: tempDir, Include: []string{"["}}.Files()
+		Ω(err).Should(MatchError(HavePrefix("Invalid pattern '['")))
+	})
+
+	It("uploads each file, carrying on past failures", func() {
+		store := memory.NewStore()
+		ctrler := Controller{Client: memory.Client{Store: store, IntegratorId: "myintegrator", ClientId: "myclient"}}
+		files, _ := FileSelection{Pattern: tempDir, Recursive: true, Include: []string{"*.csv"}}.Files()
+		store.FailTimes("UploadFile", 1, errors.New("RequestError: send request failed"))
+
+		results := ctrler.UploadDataFiles(files)
+		Ω(results).Should(HaveLen(4))
+		Ω(results[0].Err).Should(HaveOccurred())
+		Ω(results[1].FileName).Should(Equal("INPUT/b.csv"))
+		Ω(results[3].FileName).Should(Equal("INPUT/sub/deeper/d.csv"))
+		_, found := store.Get("myintegrator", "myclient/INPUT/sub/c.csv")
+		Ω(found).Should(BeTrue())
+	})
+})
@@ -0,0 +1,67 @@
+package iaas
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ChecksumMetadataKey is the object metadata holding the hex SHA-256 of an uploaded file,
+// so that the receiving side can verify the file too
+const ChecksumMetadataKey = "sha256"
+
+// UploadedAtMetadataKey is the object metadata holding when a moved file arrived, as a move, e.g. from INPUT/
+// to PROCESSED/, gives the file a new last modified time
+const UploadedAtMetadataKey = "sched-load-uploaded-at"
+
+// ChecksumFile gives the hex SHA-256 of a local file, along with the base64 MD5 sent as the Content-MD5 of an upload
+func ChecksumFile(localPath string) (checksum string, contentMD5 string, err error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	shaHash := sha256.New()
+	md5Hash := md5.New()
+	if _, err = io.Copy(io.MultiWriter(shaHash, md5Hash), file); err != nil {
+		return
+	}
+	return hex.EncodeToString(shaHash.Sum(nil)), base64.StdEncoding.EncodeToString(md5Hash.Sum(nil)), nil
+}
+
+// metadataValue looks up object metadata, whose keys S3 gives back capitalised
+func metadataValue(metadata map[string]*string, key string) string {
+	for name, value := range metadata {
+		if strings.EqualFold(name, key) && value != nil {
+			return *value
+		}
+	}
+	return ""
+}
+
+// eTag gives an S3 ETag without the quotes it is sent in
+func eTag(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return strings.Trim(*value, "\"")
+}
+
+func storageClass(value *string) string {
+	if value == nil || *value == "" {
+		return StandardStorageClass
+	}
+	return *value
+}
+
+// fileETag stands in for the ETag of a file stored on a filesystem, changing with its size & modification time,
+// without reading the file
+func fileETag(info os.FileInfo) string {
+	return strconv.FormatInt(info.ModTime().UnixNano(), 16) + "-" + strconv.FormatInt(info.Size(), 16)
+}
@@ -0,0 +1,85 @@
+package iaas
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+
+	// ContentEncodingMetadataKey is the object metadata holding the compression of a file
+	ContentEncodingMetadataKey = "content-encoding"
+	// UncompressedSizeMetadataKey is the object metadata holding the size of a compressed or encrypted file
+	// before it was compressed & encrypted
+	UncompressedSizeMetadataKey = "uncompressed-size"
+)
+
+// ValidateCompression checks a compression is known, where no compression is given by ""
+func ValidateCompression(compression string) error {
+	switch compression {
+	case "", CompressionGzip, CompressionZstd:
+		return nil
+	}
+	return errors.New("Unknown compression " + compression + ", expected gzip or zstd")
+}
+
+// Compress writes the source to the destination, compressed
+func Compress(destination io.Writer, source io.Reader, compression string) (err error) {
+	var encoder io.WriteCloser
+	switch compression {
+	case CompressionGzip:
+		encoder = gzip.NewWriter(destination)
+	case CompressionZstd:
+		if encoder, err = zstd.NewWriter(destination); err != nil {
+			return
+		}
+	default:
+		return ValidateCompression(compression)
+	}
+
+	if _, err = io.Copy(encoder, source); err != nil {
+		encoder.Close()
+		return
+	}
+	return encoder.Close()
+}
+
+// Decompress writes the compressed source to the destination, uncompressed. With no compression it is copied as is.
+func Decompress(destination io.Writer, source io.Reader, compression string) (err error) {
+	switch compression {
+	case "":
+		_, err = io.Copy(destination, source)
+	case CompressionGzip:
+		var decoder *gzip.Reader
+		if decoder, err = gzip.NewReader(source); err != nil {
+			return
+		}
+		defer decoder.Close()
+		_, err = io.Copy(destination, decoder)
+	case CompressionZstd:
+		var decoder *zstd.Decoder
+		if decoder, err = zstd.NewReader(source); err != nil {
+			return
+		}
+		defer decoder.Close()
+		_, err = io.Copy(destination, decoder)
+	default:
+		err = ValidateCompression(compression)
+	}
+	return
+}
+
+// CompressingReader streams the compressed source, so that a file is compressed as it is uploaded.
+// The reader must be closed, to stop the compression when the upload fails part way.
+func CompressingReader(source io.Reader, compression string) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(Compress(writer, source, compression))
+	}()
+	return reader
+}
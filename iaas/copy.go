@@ -0,0 +1,68 @@
+package iaas
+
+import (
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// MaxCopySize is the largest object S3 copies with a single CopyObject, larger objects are copied in parts
+	MaxCopySize = 5 * 1024 * 1024 * 1024
+	// copyPartSize copies objects up to S3's 5TB limit within its 10,000 parts
+	copyPartSize = 512 * 1024 * 1024
+)
+
+// copyAPI is the part of the S3 API used for multipart copies
+type copyAPI interface {
+	CreateMultipartUpload(input *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error)
+	UploadPartCopy(input *s3.UploadPartCopyInput) (*s3.UploadPartCopyOutput, error)
+	CompleteMultipartUpload(input *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(input *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error)
+}
+
+// multipartCopy copies an object too large for CopyObject to the upload's key, a range of the source at a time.
+// The copy is aborted when a part fails, so that no incomplete upload is left to be charged for.
+func multipartCopy(svc copyAPI, upload *s3.CreateMultipartUploadInput, copySource string, size int64, partSize int64) (err error) {
+	output, err := svc.CreateMultipartUpload(upload)
+	if err != nil {
+		return
+	}
+	uploadId := output.UploadId
+
+	var parts []*s3.CompletedPart
+	for number := int64(1); (number-1)*partSize < size; number++ {
+		offset, length := partBounds(size, partSize, number)
+		var part *s3.UploadPartCopyOutput
+		part, err = svc.UploadPartCopy(&s3.UploadPartCopyInput{
+			Bucket:          upload.Bucket,
+			Key:             upload.Key,
+			UploadId:        uploadId,
+			PartNumber:      aws.Int64(number),
+			CopySource:      aws.String(copySource),
+			CopySourceRange: aws.String("bytes=" + strconv.FormatInt(offset, 10) + "-" + strconv.FormatInt(offset+length-1, 10)),
+		})
+		if err != nil {
+			break
+		}
+		parts = append(parts, &s3.CompletedPart{PartNumber: aws.Int64(number), ETag: part.CopyPartResult.ETag})
+	}
+
+	if err == nil {
+		_, err = svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+			Bucket:          upload.Bucket,
+			Key:             upload.Key,
+			UploadId:        uploadId,
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		})
+	}
+	if err != nil {
+		svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   upload.Bucket,
+			Key:      upload.Key,
+			UploadId: uploadId,
+		})
+	}
+	return
+}
@@ -0,0 +1,50 @@
+package iaas
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// Where AWS credentials come from: the AWS_ACCESS_KEY_ID & AWS_SECRET_ACCESS_KEY environment variables, or the
+// shared credentials file, ~/.aws/credentials. With neither, the SDK tries each in turn, then the instance's role.
+const (
+	EnvCredentials    = "env"
+	SharedCredentials = "shared"
+)
+
+// ValidateCredentials checks the credentials source is known, & that a profile is only given for shared credentials
+func ValidateCredentials(source string, profile string) error {
+	switch source {
+	case "", EnvCredentials, SharedCredentials:
+	default:
+		return errors.New("Unknown credentials source " + source + ", expected env or shared")
+	}
+	if profile != "" && source != SharedCredentials {
+		return errors.New("A credentials profile can only be given for shared credentials")
+	}
+	return nil
+}
+
+// ParseProxy checks the proxy is an absolute URL, e.g. http://proxy.example.com:3128
+func ParseProxy(proxy string) (proxyURL *url.URL, err error) {
+	if proxyURL, err = url.Parse(proxy); err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+		return nil, errors.New("Invalid proxy URL " + proxy + ", expected e.g. http://proxy.example.com:3128")
+	}
+	return
+}
+
+// credentials gives the credentials from the chosen source, or nil for the SDK's usual chain
+func (client AwsClient) credentials() (*credentials.Credentials, error) {
+	if err := ValidateCredentials(client.Credentials, client.CredentialsProfile); err != nil {
+		return nil, err
+	}
+	switch client.Credentials {
+	case EnvCredentials:
+		return credentials.NewEnvCredentials(), nil
+	case SharedCredentials:
+		return credentials.NewSharedCredentials("", client.CredentialsProfile), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,116 @@
+package iaas
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// What a download does when the local file already exists: replace it, leave it be, or download to a new name
+const (
+	OverwriteExisting = "overwrite"
+	SkipExisting      = "skip"
+	RenameNew         = "rename"
+)
+
+// ErrSkipped is given by a download skipped as the local file already exists, along with the existing file's path,
+// so that the remote file is not treated as downloaded, e.g. moved out of INPUT/
+var ErrSkipped = errors.New("Skipped as the local file already exists")
+
+// ValidateConflictPolicy checks the policy is known, with no policy meaning overwrite
+func ValidateConflictPolicy(policy string) error {
+	switch policy {
+	case "", OverwriteExisting, SkipExisting, RenameNew:
+		return nil
+	}
+	return errors.New("Unknown conflict policy " + policy + ", expected overwrite, skip or rename")
+}
+
+// LocalPath gives where a remote file is downloaded to, mirroring its path beneath the local directory,
+// e.g. INPUT/2017/extract.csv to <dir>/INPUT/2017/extract.csv
+func LocalPath(localDir string, remotePath string) (string, error) {
+	cleanPath := path.Clean("/" + remotePath)
+	if cleanPath == "/" || strings.HasSuffix(remotePath, "/") {
+		return "", errors.New("Invalid remote file path: " + remotePath)
+	}
+	return filepath.Join(localDir, filepath.FromSlash(cleanPath)), nil
+}
+
+// resolveConflict gives the local path to download to under the policy, & whether the download is skipped
+// as the file already exists. Renamed downloads are numbered, e.g. extract-1.csv.
+func resolveConflict(localPath string, policy string) (target string, skip bool, err error) {
+	if err = ValidateConflictPolicy(policy); err != nil {
+		return
+	}
+	if !exists(localPath) {
+		return localPath, false, nil
+	}
+	switch policy {
+	case SkipExisting:
+		return localPath, true, nil
+	case RenameNew:
+		extension := filepath.Ext(localPath)
+		base := strings.TrimSuffix(localPath, extension)
+		for i := 1; ; i++ {
+			if target = base + "-" + strconv.Itoa(i) + extension; !exists(target) {
+				return
+			}
+		}
+	}
+	return localPath, false, nil
+}
+
+// DownloadTo downloads a remote file to its mirrored path beneath the local directory, as the conflict policy says.
+// The download is written to a temp file & decoded, then renamed into place, so that an interrupted or undecodable
+// download never leaves a file looking like the original.
+func DownloadTo(localDir string, remotePath string, policy string, download func(file *os.File) error,
+	metadata func(key string) string, encryption KeyWrapper) (downloadedFilePath string, err error) {
+
+	localPath, err := LocalPath(localDir, remotePath)
+	if err != nil {
+		return
+	}
+	downloadedFilePath, skip, err := resolveConflict(localPath, policy)
+	if err != nil {
+		return
+	}
+	if skip {
+		log.Println("Skipped", remotePath, "as", downloadedFilePath, "already exists")
+		return downloadedFilePath, ErrSkipped
+	}
+
+	if err = os.MkdirAll(filepath.Dir(downloadedFilePath), 0755); err != nil {
+		return
+	}
+	tempFile, err := ioutil.TempFile(filepath.Dir(downloadedFilePath), "."+filepath.Base(downloadedFilePath)+".partial")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tempFile.Close()
+			os.Remove(tempFile.Name())
+			downloadedFilePath = ""
+		}
+	}()
+
+	if err = download(tempFile); err != nil {
+		return
+	}
+	if err = tempFile.Close(); err != nil {
+		return
+	}
+	if err = decodeFile(tempFile.Name(), metadata, encryption); err != nil {
+		return
+	}
+	if err = os.Chmod(tempFile.Name(), 0644); err != nil {
+		return
+	}
+	err = os.Rename(tempFile.Name(), downloadedFilePath)
+	return
+}
@@ -0,0 +1,81 @@
+package iaas_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/dhrapson/sched-load/iaas"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Downloads", func() {
+
+	var (
+		rootDir  string
+		localDir string
+		fsClient FilesystemClient
+		err      error
+	)
+
+	BeforeEach(func() {
+		rootDir, err = ioutil.TempDir("", "iaas-download")
+		Ω(err).ShouldNot(HaveOccurred())
+		localDir = filepath.Join(rootDir, "downloads")
+		fsClient = FilesystemClient{Root: rootDir, IntegratorId: "myintegrator", ClientId: "myclient"}
+		_, _, err = fsClient.UploadFile("fixtures/test-file.csv", "INPUT/2017/test-file.csv")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(rootDir)
+	})
+
+	download := func() string {
+		localPath, err := fsClient.GetFile("INPUT/2017/test-file.csv", localDir)
+		Ω(err).ShouldNot(HaveOccurred())
+		return localPath
+	}
+
+	It("mirrors the remote path beneath the local directory", func() {
+		Ω(download()).Should(Equal(filepath.Join(localDir, "INPUT", "2017", "test-file.csv")))
+	})
+
+	It("overwrites an existing file by default", func() {
+		localPath := download()
+		Ω(ioutil.WriteFile(localPath, []byte("old"), 0644)).Should(Succeed())
+		Ω(download()).Should(Equal(localPath))
+		expected, _ := ioutil.ReadFile("fixtures/test-file.csv")
+		Ω(ioutil.ReadFile(localPath)).Should(Equal(expected))
+	})
+
+	It("leaves an existing file be when skipping", func() {
+		localPath := download()
+		Ω(ioutil.WriteFile(localPath, []byte("old"), 0644)).Should(Succeed())
+		fsClient.OnConflict = SkipExisting
+		skippedPath, err := fsClient.GetFile("INPUT/2017/test-file.csv", localDir)
+		Ω(err).Should(Equal(ErrSkipped))
+		Ω(skippedPath).Should(Equal(localPath))
+		Ω(ioutil.ReadFile(localPath)).Should(Equal([]byte("old")))
+	})
+
+	It("numbers the new file when renaming", func() {
+		download()
+		fsClient.OnConflict = RenameNew
+		Ω(download()).Should(Equal(filepath.Join(localDir, "INPUT", "2017", "test-file-1.csv")))
+		Ω(download()).Should(Equal(filepath.Join(localDir, "INPUT", "2017", "test-file-2.csv")))
+	})
+
+	It("rejects an unknown conflict policy", func() {
+		fsClient.OnConflict = "append"
+		_, err = fsClient.GetFile("INPUT/2017/test-file.csv", localDir)
+		Ω(err).Should(MatchError("Unknown conflict policy append, expected overwrite, skip or rename"))
+	})
+
+	It("keeps downloads within the local directory", func() {
+		Ω(LocalPath(localDir, "../../etc/passwd")).Should(Equal(filepath.Join(localDir, "etc", "passwd")))
+		_, err = LocalPath(localDir, "INPUT/")
+		Ω(err).Should(MatchError("Invalid remote file path: INPUT/"))
+	})
+})
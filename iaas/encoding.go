@@ -0,0 +1,208 @@
+package iaas
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// UploadStream is a local file opened for upload, compressed & encrypted as it is read
+type UploadStream struct {
+	io.Reader
+	// Metadata is to be stored with the upload, holding the checksum of the local file, sealed when it is encrypted,
+	// & how it was encoded
+	Metadata map[string]string
+	// Size, ContentMD5 & Checksum, its hex SHA-256, are those of the local file, which are only those of the stream
+	// when it is not Encoded
+	Size       int64
+	ContentMD5 string
+	Checksum   string
+	Encoded    bool
+	closers    []io.Closer
+}
+
+// OpenUpload opens a local file for upload, compressing it & encrypting it with a new data key wrapped by the
+// client's key, as configured. The stream must be closed.
+func OpenUpload(localPath string, compression string, encryption KeyWrapper) (stream *UploadStream, err error) {
+	if err = ValidateCompression(compression); err != nil {
+		return
+	}
+	checksum, contentMD5, err := ChecksumFile(localPath)
+	if err != nil {
+		return
+	}
+	file, err := os.Open(localPath)
+	if err != nil {
+		return
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return
+	}
+
+	stream = &UploadStream{
+		Reader:     file,
+		Metadata:   map[string]string{ChecksumMetadataKey: checksum},
+		Size:       info.Size(),
+		ContentMD5: contentMD5,
+		Checksum:   checksum,
+		closers:    []io.Closer{file},
+	}
+
+	if compression != "" {
+		compressed := CompressingReader(stream.Reader, compression)
+		stream.Reader = compressed
+		stream.closers = append(stream.closers, compressed)
+		stream.Metadata[ContentEncodingMetadataKey] = compression
+		stream.Encoded = true
+	}
+
+	// compress before encrypting, as encrypted data does not compress
+	if encryption != nil {
+		var dataKey, wrappedKey []byte
+		var keyId, sealedChecksum string
+		if dataKey, err = NewDataKey(); err == nil {
+			keyId, wrappedKey, err = encryption.WrapKey(dataKey)
+		}
+		if err == nil {
+			sealedChecksum, err = sealChecksum(checksum, dataKey)
+		}
+		if err != nil {
+			stream.Close()
+			return nil, err
+		}
+		reader, writer := io.Pipe()
+		source := stream.Reader
+		go func() {
+			writer.CloseWithError(Encrypt(writer, source, dataKey))
+		}()
+		stream.Reader = reader
+		stream.closers = append(stream.closers, reader)
+		stream.Metadata[EncryptionKeyIdMetadataKey] = keyId
+		stream.Metadata[WrappedKeyMetadataKey] = base64.StdEncoding.EncodeToString(wrappedKey)
+		stream.Metadata[SealedChecksumMetadataKey] = sealedChecksum
+		delete(stream.Metadata, ChecksumMetadataKey)
+		stream.Encoded = true
+	}
+
+	if stream.Encoded {
+		stream.Metadata[UncompressedSizeMetadataKey] = strconv.FormatInt(stream.Size, 10)
+	}
+	return
+}
+
+// Close stops any compression & encryption, then closes the file
+func (stream *UploadStream) Close() (err error) {
+	for i := len(stream.closers) - 1; i >= 0; i-- {
+		if closeErr := stream.closers[i].Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return
+}
+
+// Decode writes the contents of a stored file to the destination, decrypting & decompressing it as its metadata says,
+// & verifying a decrypted file against its sealed checksum
+func Decode(destination io.Writer, source io.Reader, metadata func(key string) string, encryption KeyWrapper) (err error) {
+	var checksum string
+	if keyId := metadata(EncryptionKeyIdMetadataKey); keyId != "" {
+		if encryption == nil {
+			return errors.New("File is encrypted with key " + keyId + ", which is needed to decrypt it")
+		}
+		var wrappedKey, dataKey []byte
+		if wrappedKey, err = base64.StdEncoding.DecodeString(metadata(WrappedKeyMetadataKey)); err != nil {
+			return
+		}
+		if dataKey, err = encryption.UnwrapKey(keyId, wrappedKey); err != nil {
+			return
+		}
+		if sealed := metadata(SealedChecksumMetadataKey); sealed != "" {
+			if checksum, err = openChecksum(sealed, dataKey); err != nil {
+				return
+			}
+		}
+		reader, writer := io.Pipe()
+		encrypted := source
+		go func() {
+			writer.CloseWithError(Decrypt(writer, encrypted, dataKey))
+		}()
+		defer reader.Close()
+		source = reader
+	}
+	if checksum == "" {
+		return Decompress(destination, source, metadata(ContentEncodingMetadataKey))
+	}
+
+	hash := sha256.New()
+	if err = Decompress(io.MultiWriter(destination, hash), source, metadata(ContentEncodingMetadataKey)); err != nil {
+		return
+	}
+	if decrypted := hex.EncodeToString(hash.Sum(nil)); decrypted != checksum {
+		err = errors.New("Decrypted file has checksum '" + decrypted + "', expected '" + checksum + "'")
+	}
+	return
+}
+
+// decodeFile replaces a downloaded file that was compressed or encrypted by its original contents,
+// removing it when it cannot be decoded so that no encoded file is left looking like the original
+func decodeFile(localPath string, metadata func(key string) string, encryption KeyWrapper) (err error) {
+	if metadata(ContentEncodingMetadataKey) == "" && metadata(EncryptionKeyIdMetadataKey) == "" {
+		return
+	}
+	encoded, err := os.Open(localPath)
+	if err != nil {
+		return
+	}
+	defer encoded.Close()
+	defer func() {
+		if err != nil {
+			os.Remove(localPath)
+		}
+	}()
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(Decode(writer, encoded, metadata, encryption))
+	}()
+	defer reader.Close()
+	return copyAtomically(reader, localPath)
+}
+
+// FileInfoFromMetadata gives the details of a stored file, looking up its metadata by key
+func FileInfoFromMetadata(name string, size int64, lastModified time.Time, metadata func(key string) string) FileInfo {
+	info := FileInfo{
+		Name:            name,
+		Size:            size,
+		LastModified:    lastModified,
+		Checksum:        metadata(ChecksumMetadataKey),
+		Compression:     metadata(ContentEncodingMetadataKey),
+		EncryptionKeyId: metadata(EncryptionKeyIdMetadataKey),
+		UploadedAt:      lastModified,
+	}
+	if uncompressedSize := metadata(UncompressedSizeMetadataKey); uncompressedSize != "" {
+		info.UncompressedSize, _ = strconv.ParseInt(uncompressedSize, 10, 64)
+	}
+	if uploadedAt, err := time.Parse(time.RFC3339Nano, metadata(UploadedAtMetadataKey)); err == nil {
+		info.UploadedAt = uploadedAt
+	}
+	return info
+}
+
+// MovedMetadata gives the metadata of a file once moved, recording when it arrived unless it already was
+func MovedMetadata(metadata map[string]string, lastModified time.Time) map[string]string {
+	moved := map[string]string{UploadedAtMetadataKey: formatUploadedAt(lastModified)}
+	for key, value := range metadata {
+		moved[key] = value
+	}
+	return moved
+}
+
+func formatUploadedAt(uploadedAt time.Time) string {
+	return uploadedAt.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,367 @@
+package iaas
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// EncryptionKeyIdMetadataKey & WrappedKeyMetadataKey are the object metadata holding the ID of the client key
+	// that wrapped the data key of an encrypted file, & the wrapped data key itself
+	EncryptionKeyIdMetadataKey = "encryption-key-id"
+	WrappedKeyMetadataKey      = "encryption-wrapped-key"
+	// SealedChecksumMetadataKey holds the checksum of an encrypted file, encrypted with its data key
+	SealedChecksumMetadataKey = "encryption-sealed-sha256"
+
+	rsaKeyIdPrefix      = "rsa-oaep:"
+	localKMSKeyIdPrefix = "local-kms:"
+
+	encryptionMagic     = "SLE1"
+	encryptionChunkSize = 64 * 1024
+	dataKeySize         = 32
+)
+
+// KeyWrapper wraps the data key each file is encrypted with, so that only the holder of the client's key can unwrap it
+type KeyWrapper interface {
+	WrapKey(dataKey []byte) (keyId string, wrappedKey []byte, err error)
+	UnwrapKey(keyId string, wrappedKey []byte) (dataKey []byte, err error)
+}
+
+// RSAKeys wraps data keys with RSA-OAEP. Only the PublicKey is needed to encrypt, & only the PrivateKey to decrypt,
+// so a source system never holds the key to read back what it uploaded.
+type RSAKeys struct {
+	PublicKey  *rsa.PublicKey
+	PrivateKey *rsa.PrivateKey
+}
+
+// LoadRSAKeys reads PEM-encoded keys, either of which may be omitted
+func LoadRSAKeys(publicKeyPath string, privateKeyPath string) (keys RSAKeys, err error) {
+	if privateKeyPath != "" {
+		var block *pem.Block
+		if block, err = readPEM(privateKeyPath); err != nil {
+			return
+		}
+		if keys.PrivateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			var parsed interface{}
+			if parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+				return
+			}
+			var ok bool
+			if keys.PrivateKey, ok = parsed.(*rsa.PrivateKey); !ok {
+				return keys, errors.New("Private key " + privateKeyPath + " is not an RSA key")
+			}
+		}
+		keys.PublicKey = &keys.PrivateKey.PublicKey
+	}
+	if publicKeyPath != "" {
+		var block *pem.Block
+		if block, err = readPEM(publicKeyPath); err != nil {
+			return
+		}
+		var parsed interface{}
+		if parsed, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return
+		}
+		var ok bool
+		if keys.PublicKey, ok = parsed.(*rsa.PublicKey); !ok {
+			return keys, errors.New("Public key " + publicKeyPath + " is not an RSA key")
+		}
+	}
+	return
+}
+
+// GenerateRSAKeys gives a new PEM-encoded RSA key pair for a client
+func GenerateRSAKeys(bits int) (publicKeyPEM []byte, privateKeyPEM []byte, err error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return
+	}
+	publicKeyPEM = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+	privateKeyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	return
+}
+
+func (keys RSAKeys) keyId() (string, error) {
+	if keys.PublicKey == nil {
+		return "", errors.New("A public key is needed to encrypt files")
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(keys.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	fingerprint := sha256.Sum256(publicDER)
+	return rsaKeyIdPrefix + hex.EncodeToString(fingerprint[:8]), nil
+}
+
+func (keys RSAKeys) WrapKey(dataKey []byte) (keyId string, wrappedKey []byte, err error) {
+	if keyId, err = keys.keyId(); err != nil {
+		return
+	}
+	wrappedKey, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, keys.PublicKey, dataKey, nil)
+	return
+}
+
+func (keys RSAKeys) UnwrapKey(keyId string, wrappedKey []byte) (dataKey []byte, err error) {
+	if keys.PrivateKey == nil {
+		return nil, errors.New("A private key is needed to decrypt files")
+	}
+	ownKeyId, err := RSAKeys{PublicKey: &keys.PrivateKey.PublicKey}.keyId()
+	if err != nil {
+		return
+	}
+	if keyId != ownKeyId {
+		return nil, errors.New("File was encrypted with key " + keyId + ", not " + ownKeyId)
+	}
+	return rsa.DecryptOAEP(sha256.New(), rand.Reader, keys.PrivateKey, wrappedKey, nil)
+}
+
+// LocalKMS stands in for a key management service, keeping named AES-256 master keys as files in a directory.
+// Data keys are wrapped with the master key named by KeyId, & unwrapped with whichever master key wrapped them.
+type LocalKMS struct {
+	Dir   string
+	KeyId string
+}
+
+// CreateKey adds a new, random master key
+func (kms LocalKMS) CreateKey() (err error) {
+	if kms.KeyId == "" || strings.ContainsAny(kms.KeyId, `/\`) || strings.HasPrefix(kms.KeyId, ".") {
+		return errors.New("Invalid key ID '" + kms.KeyId + "'")
+	}
+	masterKey := make([]byte, dataKeySize)
+	if _, err = rand.Read(masterKey); err != nil {
+		return
+	}
+	if err = os.MkdirAll(kms.Dir, 0700); err != nil {
+		return
+	}
+	keyFile, err := os.OpenFile(kms.keyPath(kms.KeyId), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return errors.New("Key " + kms.KeyId + " already exists")
+	}
+	if err != nil {
+		return
+	}
+	if _, err = keyFile.WriteString(hex.EncodeToString(masterKey) + "\n"); err != nil {
+		keyFile.Close()
+		return
+	}
+	return keyFile.Close()
+}
+
+func (kms LocalKMS) WrapKey(dataKey []byte) (keyId string, wrappedKey []byte, err error) {
+	if kms.KeyId == "" {
+		return "", nil, errors.New("A key ID is needed to encrypt files")
+	}
+	keyId = localKMSKeyIdPrefix + kms.KeyId
+	aead, err := kms.masterKey(kms.KeyId)
+	if err != nil {
+		return
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return
+	}
+	wrappedKey = aead.Seal(nonce, nonce, dataKey, []byte(keyId))
+	return
+}
+
+func (kms LocalKMS) UnwrapKey(keyId string, wrappedKey []byte) (dataKey []byte, err error) {
+	if !strings.HasPrefix(keyId, localKMSKeyIdPrefix) {
+		return nil, errors.New("File was encrypted with key " + keyId + ", which is not a local KMS key")
+	}
+	aead, err := kms.masterKey(strings.TrimPrefix(keyId, localKMSKeyIdPrefix))
+	if err != nil {
+		return
+	}
+	if len(wrappedKey) < aead.NonceSize() {
+		return nil, errors.New("Wrapped key is too short")
+	}
+	return aead.Open(nil, wrappedKey[:aead.NonceSize()], wrappedKey[aead.NonceSize():], []byte(keyId))
+}
+
+func (kms LocalKMS) keyPath(keyId string) string {
+	return filepath.Join(kms.Dir, filepath.Base(keyId)+".key")
+}
+
+func (kms LocalKMS) masterKey(keyId string) (aead cipher.AEAD, err error) {
+	contents, err := ioutil.ReadFile(kms.keyPath(keyId))
+	if os.IsNotExist(err) {
+		return nil, errors.New("Key " + keyId + " not found in " + kms.Dir)
+	}
+	if err != nil {
+		return
+	}
+	masterKey, err := hex.DecodeString(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return
+	}
+	return newAEAD(masterKey)
+}
+
+// Encrypt writes the source to the destination encrypted with AES-256-GCM, in chunks so that files of any size
+// are streamed. Each chunk is sealed under its own nonce & the last is marked, so reordering or truncation is detected.
+func Encrypt(destination io.Writer, source io.Reader, dataKey []byte) (err error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return
+	}
+	baseNonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(baseNonce); err != nil {
+		return
+	}
+	if _, err = destination.Write(append([]byte(encryptionMagic), baseNonce...)); err != nil {
+		return
+	}
+
+	chunk, nextChunk := make([]byte, encryptionChunkSize), make([]byte, encryptionChunkSize)
+	current, err := readChunk(source, chunk)
+	if err != nil {
+		return
+	}
+	for index := uint64(0); ; index++ {
+		var next []byte
+		if next, err = readChunk(source, nextChunk); err != nil {
+			return
+		}
+		final := len(next) == 0
+		if _, err = destination.Write(aead.Seal(nil, chunkNonce(baseNonce, index), current, chunkAAD(final))); err != nil {
+			return
+		}
+		if final {
+			return
+		}
+		current = next
+		chunk, nextChunk = nextChunk, chunk
+	}
+}
+
+// Decrypt writes the encrypted source to the destination, decrypted
+func Decrypt(destination io.Writer, source io.Reader, dataKey []byte) (err error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return
+	}
+	header := make([]byte, len(encryptionMagic)+aead.NonceSize())
+	if _, err = io.ReadFull(source, header); err != nil || string(header[:len(encryptionMagic)]) != encryptionMagic {
+		return errors.New("File is not encrypted in a known format")
+	}
+	baseNonce := header[len(encryptionMagic):]
+
+	sealedSize := encryptionChunkSize + aead.Overhead()
+	chunk, nextChunk := make([]byte, sealedSize), make([]byte, sealedSize)
+	current, err := readChunk(source, chunk)
+	if err != nil {
+		return
+	}
+	for index := uint64(0); ; index++ {
+		var next []byte
+		if next, err = readChunk(source, nextChunk); err != nil {
+			return
+		}
+		final := len(next) == 0
+		var plaintext []byte
+		if plaintext, err = aead.Open(nil, chunkNonce(baseNonce, index), current, chunkAAD(final)); err != nil {
+			return errors.New("Unable to decrypt file, it is either corrupt or truncated")
+		}
+		if _, err = destination.Write(plaintext); err != nil {
+			return
+		}
+		if final {
+			return
+		}
+		current = next
+		chunk, nextChunk = nextChunk, chunk
+	}
+}
+
+// sealChecksum encrypts a file's checksum with its data key, as the checksum of a small file could reveal its contents
+func sealChecksum(checksum string, dataKey []byte) (sealed string, err error) {
+	var buffer bytes.Buffer
+	if err = Encrypt(&buffer, strings.NewReader(checksum), dataKey); err != nil {
+		return
+	}
+	return base64.StdEncoding.EncodeToString(buffer.Bytes()), nil
+}
+
+func openChecksum(sealed string, dataKey []byte) (checksum string, err error) {
+	encrypted, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return
+	}
+	var buffer bytes.Buffer
+	if err = Decrypt(&buffer, bytes.NewReader(encrypted), dataKey); err != nil {
+		return
+	}
+	return buffer.String(), nil
+}
+
+// NewDataKey gives a random key for encrypting a single file
+func NewDataKey() (dataKey []byte, err error) {
+	dataKey = make([]byte, dataKeySize)
+	_, err = rand.Read(dataKey)
+	return
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// readChunk fills the buffer, giving less at the end of the source
+func readChunk(source io.Reader, buffer []byte) ([]byte, error) {
+	n, err := io.ReadFull(source, buffer)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return buffer[:n], err
+}
+
+func chunkNonce(baseNonce []byte, index uint64) []byte {
+	nonce := append([]byte{}, baseNonce...)
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], index)
+	for i := range counter {
+		nonce[len(nonce)-8+i] ^= counter[i]
+	}
+	return nonce
+}
+
+func chunkAAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+func readPEM(path string) (block *pem.Block, err error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if block, _ = pem.Decode(bytes.TrimSpace(contents)); block == nil {
+		return nil, errors.New("No PEM data found in " + path)
+	}
+	return
+}
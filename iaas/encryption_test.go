@@ -0,0 +1,176 @@
+package iaas_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/dhrapson/sched-load/iaas"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client-side encryption", func() {
+
+	var (
+		tempDir string
+		err     error
+	)
+
+	BeforeEach(func() {
+		tempDir, err = ioutil.TempDir("", "iaas-encryption")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tempDir)
+	})
+
+	Context("of a stream", func() {
+		var dataKey []byte
+
+		BeforeEach(func() {
+			dataKey, err = NewDataKey()
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		encrypt := func(plaintext []byte) []byte {
+			var encrypted bytes.Buffer
+			Ω(Encrypt(&encrypted, bytes.NewReader(plaintext), dataKey)).Should(Succeed())
+			return encrypted.Bytes()
+		}
+
+		for _, size := range []int{0, 10, 64 * 1024, 200*1024 + 7} {
+			size := size
+			It("round trips a file spanning several chunks", func() {
+				plaintext := bytes.Repeat([]byte("x"), size)
+				var decrypted bytes.Buffer
+				Ω(Decrypt(&decrypted, bytes.NewReader(encrypt(plaintext)), dataKey)).Should(Succeed())
+				Ω(decrypted.Bytes()).Should(Equal(plaintext))
+			})
+		}
+
+		It("detects a truncated file", func() {
+			encrypted := encrypt(bytes.Repeat([]byte("x"), 200*1024))
+			truncated := encrypted[:len(encrypted)-(64*1024+16)]
+			err = Decrypt(ioutil.Discard, bytes.NewReader(truncated), dataKey)
+			Ω(err).Should(MatchError("Unable to decrypt file, it is either corrupt or truncated"))
+		})
+
+		It("detects a tampered file", func() {
+			encrypted := encrypt([]byte("a,b,c"))
+			encrypted[len(encrypted)-1] ^= 1
+			Ω(Decrypt(ioutil.Discard, bytes.NewReader(encrypted), dataKey)).ShouldNot(Succeed())
+		})
+	})
+
+	Context("with RSA keys", func() {
+		var keys RSAKeys
+
+		BeforeEach(func() {
+			publicPEM, privatePEM, err := GenerateRSAKeys(2048)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(ioutil.WriteFile(filepath.Join(tempDir, "public.pem"), publicPEM, 0644)).Should(Succeed())
+			Ω(ioutil.WriteFile(filepath.Join(tempDir, "private.pem"), privatePEM, 0600)).Should(Succeed())
+			keys, err = LoadRSAKeys(filepath.Join(tempDir, "public.pem"), filepath.Join(tempDir, "private.pem"))
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("wraps a data key that only the private key unwraps", func() {
+			publicOnly, err := LoadRSAKeys(filepath.Join(tempDir, "public.pem"), "")
+			Ω(err).ShouldNot(HaveOccurred())
+			keyId, wrappedKey, err := publicOnly.WrapKey([]byte("0123456789abcdef0123456789abcdef"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(keyId).Should(HavePrefix("rsa-oaep:"))
+
+			_, err = publicOnly.UnwrapKey(keyId, wrappedKey)
+			Ω(err).Should(MatchError("A private key is needed to decrypt files"))
+			Ω(keys.UnwrapKey(keyId, wrappedKey)).Should(Equal([]byte("0123456789abcdef0123456789abcdef")))
+		})
+
+		It("rejects a key wrapped by another key pair", func() {
+			_, otherPEM, _ := GenerateRSAKeys(2048)
+			Ω(ioutil.WriteFile(filepath.Join(tempDir, "other.pem"), otherPEM, 0600)).Should(Succeed())
+			other, _ := LoadRSAKeys("", filepath.Join(tempDir, "other.pem"))
+
+			keyId, wrappedKey, _ := keys.WrapKey([]byte("0123456789abcdef0123456789abcdef"))
+			_, err = other.UnwrapKey(keyId, wrappedKey)
+			Ω(err).Should(MatchError(HavePrefix("File was encrypted with key " + keyId)))
+		})
+
+		It("encrypts uploads & decrypts downloads", func() {
+			fsClient := FilesystemClient{Root: tempDir, IntegratorId: "myintegrator", ClientId: "myclient", Compression: CompressionGzip, Encryption: keys}
+			_, _, err = fsClient.UploadFile("fixtures/test-file.csv", "INPUT/test-file.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			expected, _ := ioutil.ReadFile("fixtures/test-file.csv")
+			stored, _ := ioutil.ReadFile(filepath.Join(tempDir, "myintegrator", "myclient", "INPUT", "test-file.csv"))
+			Ω(bytes.Contains(stored, expected[:20])).Should(BeFalse())
+
+			info, err := fsClient.StatFile("INPUT/test-file.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(info.EncryptionKeyId).Should(HavePrefix("rsa-oaep:"))
+			Ω(info.UncompressedSize).Should(Equal(int64(len(expected))))
+
+			localPath, err := fsClient.GetFile("INPUT/test-file.csv", filepath.Join(tempDir, "downloads"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(ioutil.ReadFile(localPath)).Should(Equal(expected))
+
+			fsClient.Encryption = nil
+			_, err = fsClient.GetFile("INPUT/test-file.csv", filepath.Join(tempDir, "downloads"))
+			Ω(err).Should(MatchError(HavePrefix("File is encrypted with key rsa-oaep:")))
+			// the failed download leaves neither a partial file nor the encrypted contents in place of the earlier one
+			Ω(ioutil.ReadFile(localPath)).Should(Equal(expected))
+			Ω(filepath.Glob(filepath.Join(filepath.Dir(localPath), "*"))).Should(Equal([]string{localPath}))
+			Ω(filepath.Glob(filepath.Join(filepath.Dir(localPath), ".*"))).Should(BeEmpty())
+		})
+	})
+
+	Context("of an upload's checksum", func() {
+		It("seals it with the data key & checks it once decrypted", func() {
+			keys := LocalKMS{Dir: filepath.Join(tempDir, "kms"), KeyId: "myclient"}
+			Ω(keys.CreateKey()).Should(Succeed())
+
+			encode := func(localPath string) (contents []byte, metadata map[string]string) {
+				stream, err := OpenUpload(localPath, "", keys)
+				Ω(err).ShouldNot(HaveOccurred())
+				defer stream.Close()
+				contents, err = ioutil.ReadAll(stream)
+				Ω(err).ShouldNot(HaveOccurred())
+				return contents, stream.Metadata
+			}
+			contents, metadata := encode("fixtures/test-file.csv")
+			Ω(metadata).ShouldNot(HaveKey(ChecksumMetadataKey))
+			Ω(metadata[SealedChecksumMetadataKey]).ShouldNot(ContainSubstring("d3e883a6"))
+
+			var decoded bytes.Buffer
+			Ω(Decode(&decoded, bytes.NewReader(contents), func(key string) string { return metadata[key] }, keys)).Should(Succeed())
+			Ω(ioutil.ReadFile("fixtures/test-file.csv")).Should(Equal(decoded.Bytes()))
+
+			Ω(ioutil.WriteFile(filepath.Join(tempDir, "other.csv"), []byte("a,b\n"), 0644)).Should(Succeed())
+			_, otherMetadata := encode(filepath.Join(tempDir, "other.csv"))
+			metadata[SealedChecksumMetadataKey] = otherMetadata[SealedChecksumMetadataKey]
+			err = Decode(&bytes.Buffer{}, bytes.NewReader(contents), func(key string) string { return metadata[key] }, keys)
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("with a local KMS", func() {
+		It("wraps data keys with a named master key", func() {
+			kms := LocalKMS{Dir: filepath.Join(tempDir, "kms"), KeyId: "myclient"}
+			Ω(kms.CreateKey()).Should(Succeed())
+			Ω(kms.CreateKey()).Should(MatchError("Key myclient already exists"))
+
+			keyId, wrappedKey, err := kms.WrapKey([]byte("0123456789abcdef0123456789abcdef"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(keyId).Should(Equal("local-kms:myclient"))
+
+			// decrypting needs only the directory, as the key ID comes from the file's metadata
+			Ω(LocalKMS{Dir: kms.Dir}.UnwrapKey(keyId, wrappedKey)).Should(Equal([]byte("0123456789abcdef0123456789abcdef")))
+
+			_, err = LocalKMS{Dir: kms.Dir}.UnwrapKey("local-kms:otherclient", wrappedKey)
+			Ω(err).Should(MatchError("Key otherclient not found in " + kms.Dir))
+		})
+	})
+})
@@ -0,0 +1,68 @@
+package iaas_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	. "github.com/dhrapson/sched-load/iaas"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("The AWS client with a custom endpoint", func() {
+
+	var (
+		endpointClient AwsClient
+		err            error
+	)
+
+	BeforeEach(func() {
+		endpointClient = AwsClient{Endpoint: "https://localhost:9000", PathStyle: true, IntegratorId: "myintegrator", ClientId: "myclient"}
+	})
+
+	Context("when getting account status", func() {
+		It("uses the given integrator rather than IAM", func() {
+			details, err := endpointClient.AccountDetails()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(details["AccountId"]).Should(Equal("https://localhost:9000"))
+			Ω(details["IntegratorId"]).Should(Equal("myintegrator"))
+			Ω(details["ClientId"]).Should(Equal("myclient"))
+			Ω(details["CredentialType"]).Should(Equal("s3-compatible"))
+		})
+
+		It("requires an integrator", func() {
+			endpointClient.IntegratorId = ""
+			_, err = endpointClient.AccountDetails()
+			Ω(err).Should(MatchError("You must specify an integrator when using a custom endpoint"))
+		})
+	})
+
+	Context("when managing client users", func() {
+		It("does not use IAM", func() {
+			_, err = endpointClient.CreateClientUser()
+			Ω(err).Should(MatchError("Client users are not supported with a custom endpoint"))
+			_, err = endpointClient.DeleteClientUser(true)
+			Ω(err).Should(MatchError("Client users are not supported with a custom endpoint"))
+		})
+	})
+
+	Context("when the CA bundle is unusable", func() {
+		It("throws an error for a missing bundle", func() {
+			endpointClient.CABundle = "fixtures/does-not-exist.pem"
+			_, err = endpointClient.ListFiles()
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("throws an error for a bundle without certificates", func() {
+			bundle, err := ioutil.TempFile("", "ca-bundle")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.Remove(bundle.Name())
+			bundle.WriteString("not a certificate")
+			bundle.Close()
+
+			endpointClient.CABundle = bundle.Name()
+			_, err = endpointClient.ListFiles()
+			Ω(err).Should(MatchError("No certificates found in CA bundle: " + bundle.Name()))
+		})
+	})
+})
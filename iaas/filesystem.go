@@ -0,0 +1,656 @@
+package iaas
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FilesystemClient implements IaaSClient against a local or mounted directory,
+// laid out as <Root>/<IntegratorId>/<ClientId>/INPUT/...
+// Client accounts and upload notifications are recorded in hidden directories
+// alongside the client directories, so they never appear in a client's file list.
+type FilesystemClient struct {
+	Root         string
+	IntegratorId string
+	ClientId     string
+	// Compression, gzip or zstd, compresses files as they are uploaded, & Encryption encrypts them client-side
+	Compression string
+	Encryption  KeyWrapper
+	// OnConflict is what GetFile does when the local file already exists: overwrite, skip or rename
+	OnConflict string
+}
+
+type FilesystemCredentials struct {
+	ClientId  string
+	SecretKey string
+}
+
+func (creds FilesystemCredentials) String() (output string) {
+	return "ClientId: " + creds.ClientId + ", SecretKey: " + creds.SecretKey
+}
+
+func (creds FilesystemCredentials) Map() map[string]string {
+	m := make(map[string]string)
+	m["ClientId"] = creds.ClientId
+	m["SecretKey"] = creds.SecretKey
+	return m
+}
+
+func (client FilesystemClient) RemoveFileUploadNotification() (wasPreExisting bool, err error) {
+
+	if err = client.populate(); err != nil {
+		return
+	}
+
+	marker := client.notificationPath()
+	if !exists(marker) {
+		log.Println("Upload notifications not found when attempting removal for", client.ClientId)
+		return
+	}
+	wasPreExisting = true
+
+	if err = os.Remove(marker); err != nil {
+		return
+	}
+	log.Println("Upload notification removed for", client.ClientId)
+	return
+}
+
+func (client FilesystemClient) FileUploadNotification() (isSet bool, err error) {
+
+	if err = client.populate(); err != nil {
+		return
+	}
+
+	isSet = exists(client.notificationPath())
+	if isSet {
+		log.Println("Upload notifications are set for", client.ClientId)
+	} else {
+		log.Println("Upload notifications are not set for", client.ClientId)
+	}
+	return
+}
+
+func (client FilesystemClient) AddFileUploadNotification() (wasNewConfiguration bool, err error) {
+
+	if err = client.populate(); err != nil {
+		return
+	}
+
+	marker := client.notificationPath()
+	if exists(marker) {
+		log.Println("Upload notifications were already configured when adding for", client.ClientId)
+		return
+	}
+	wasNewConfiguration = true
+
+	if err = os.MkdirAll(filepath.Dir(marker), 0755); err != nil {
+		return
+	}
+	// the marker holds the prefix being watched, as the S3 notification filter does
+	err = ioutil.WriteFile(marker, []byte(client.ClientId+"/INPUT\n"), 0644)
+	if err != nil {
+		return
+	}
+	log.Println("Upload notifications added for", client.ClientId)
+	return
+}
+
+func (client FilesystemClient) ListFiles() (names []string, err error) {
+	names = []string{}
+
+	files, err := client.ListFileInfos()
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	return
+}
+
+func (client FilesystemClient) ListFileInfos() (files []FileInfo, err error) {
+	files = []FileInfo{}
+	err = client.WalkFiles("", func(file FileInfo) error {
+		files = append(files, file)
+		return nil
+	})
+	return
+}
+
+// WalkFiles gives the client's files whose names start with the prefix, walking only the directory the prefix is in
+func (client FilesystemClient) WalkFiles(prefix string, walkFn WalkFunc) (err error) {
+
+	if err = client.populate(); err != nil {
+		return
+	}
+
+	clientDir := client.clientDir()
+	startDir := clientDir
+	if prefixDir := path.Dir("/" + prefix); prefixDir != "/" {
+		startDir = filepath.Join(clientDir, filepath.FromSlash(prefixDir))
+	}
+	if !exists(startDir) {
+		return
+	}
+
+	err = walkInKeyOrder(startDir, func(filePath string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if info.IsDir() || isPartialFile(info.Name()) {
+			return nil
+		}
+		relativePath, relErr := filepath.Rel(clientDir, filePath)
+		if relErr != nil {
+			return relErr
+		}
+		name := filepath.ToSlash(relativePath)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		return walkFn(FileInfo{
+			Name:         name,
+			Size:         info.Size(),
+			LastModified: info.ModTime().UTC(),
+			ETag:         fileETag(info),
+			StorageClass: StandardStorageClass,
+		})
+	})
+	if err == StopWalk {
+		return nil
+	}
+	if err != nil {
+		log.Println(err.Error())
+	}
+	return
+}
+
+// walkInKeyOrder walks the files beneath a directory as filepath.Walk does, but in the order S3 lists keys, by their
+// slash-separated paths, e.g. a-b.csv before a/b.csv, so that a walk can be resumed after the last file given
+func walkInKeyOrder(dir string, walkFn filepath.WalkFunc) (err error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return walkFn(dir, nil, err)
+	}
+	keyName := func(info os.FileInfo) string {
+		if info.IsDir() {
+			return info.Name() + "/"
+		}
+		return info.Name()
+	}
+	sort.Slice(entries, func(i, j int) bool { return keyName(entries[i]) < keyName(entries[j]) })
+
+	for _, entry := range entries {
+		entryPath := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			err = walkInKeyOrder(entryPath, walkFn)
+		} else {
+			err = walkFn(entryPath, entry, nil)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// StatFile describes an uploaded file, including the checksum stored with it on upload
+func (client FilesystemClient) StatFile(remotePath string) (info FileInfo, err error) {
+
+	if err = client.populate(); err != nil {
+		return
+	}
+
+	targetFile, err := client.filePath(remotePath)
+	if err != nil {
+		return
+	}
+
+	fileInfo, err := os.Stat(targetFile)
+	if err != nil {
+		return
+	}
+	metadata, err := client.readMetadata(remotePath)
+	if err != nil {
+		return
+	}
+
+	info = FileInfoFromMetadata(remotePath, fileInfo.Size(), fileInfo.ModTime().UTC(), func(key string) string {
+		return metadata[key]
+	})
+	info.ETag = fileETag(fileInfo)
+	info.StorageClass = StandardStorageClass
+	return
+}
+
+// ListClients gives the clients with an upload area under the integrator's directory
+func (client FilesystemClient) ListClients() (clientIds []string, err error) {
+	clientIds = []string{}
+
+	if _, err = client.AccountDetails(); err != nil {
+		return
+	}
+
+	integratorDir := client.integratorDir()
+	if !exists(integratorDir) {
+		return
+	}
+
+	entries, err := ioutil.ReadDir(integratorDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		// the integrator's own records are kept in hidden directories
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			clientIds = append(clientIds, entry.Name())
+		}
+	}
+	return
+}
+
+func (client FilesystemClient) ForClient(clientId string) IaaSClient {
+	client.ClientId = clientId
+	return client
+}
+
+func (client FilesystemClient) WithoutEncoding() IaaSClient {
+	client.Compression, client.Encryption = "", nil
+	return client
+}
+
+// EncryptionStatus is always none, as the files are only as secure as the filesystem beneath the root
+func (client FilesystemClient) EncryptionStatus() (description string, err error) {
+	return SSENone, nil
+}
+
+// AbortIncompleteUploads removes the partial files left behind by uploads interrupted before the given time
+func (client FilesystemClient) AbortIncompleteUploads(initiatedBefore time.Time) (names []string, err error) {
+	names = []string{}
+
+	if err = client.populate(); err != nil {
+		return
+	}
+
+	clientDir := client.clientDir()
+	if !exists(clientDir) {
+		return
+	}
+
+	err = filepath.Walk(clientDir, func(filePath string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if info.IsDir() || !isPartialFile(info.Name()) || !info.ModTime().Before(initiatedBefore) {
+			return nil
+		}
+		if removeErr := os.Remove(filePath); removeErr != nil {
+			return removeErr
+		}
+		relativePath, relErr := filepath.Rel(clientDir, filePath)
+		if relErr != nil {
+			return relErr
+		}
+		names = append(names, filepath.ToSlash(relativePath))
+		return nil
+	})
+	if err != nil {
+		log.Println(err.Error())
+	}
+	return
+}
+
+func (client FilesystemClient) DeleteFile(remotePath string) (wasPreExisting bool, err error) {
+
+	if err = client.populate(); err != nil {
+		return
+	}
+
+	targetFile, err := client.filePath(remotePath)
+	if err != nil {
+		return
+	}
+
+	if info, statErr := os.Stat(targetFile); statErr != nil || info.IsDir() {
+		return
+	}
+
+	wasPreExisting = true
+
+	if err = os.Remove(targetFile); err != nil {
+		log.Println(err.Error())
+		return
+	}
+	if err = os.Remove(client.metadataPath(remotePath)); os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
+
+// DeleteFiles deletes each file in turn, giving those that existed
+func (client FilesystemClient) DeleteFiles(remotePaths []string) (deleted []string, err error) {
+	deleted = []string{}
+	for _, remotePath := range remotePaths {
+		var wasPreExisting bool
+		if wasPreExisting, err = client.DeleteFile(remotePath); err != nil {
+			return
+		}
+		if wasPreExisting {
+			deleted = append(deleted, remotePath)
+		}
+	}
+	return
+}
+
+// MoveFile renames a file within the client directory, along with its metadata
+func (client FilesystemClient) MoveFile(sourcePath string, targetPath string) (err error) {
+
+	if err = client.populate(); err != nil {
+		return
+	}
+
+	sourceFile, err := client.filePath(sourcePath)
+	if err != nil {
+		return
+	}
+	targetFile, err := client.filePath(targetPath)
+	if err != nil {
+		return
+	}
+	if info, statErr := os.Stat(sourceFile); statErr != nil || info.IsDir() {
+		return errors.New("NoSuchKey: The specified key does not exist: " + sourcePath)
+	}
+
+	// the metadata goes first, so that a moved file is never without it
+	metadata, err := client.readMetadata(sourcePath)
+	if err != nil {
+		return
+	}
+	if info, statErr := os.Stat(sourceFile); statErr == nil {
+		metadata = MovedMetadata(metadata, info.ModTime())
+	}
+	if err = client.writeMetadata(targetPath, metadata); err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(targetFile), 0755); err != nil {
+		return
+	}
+	if err = os.Rename(sourceFile, targetFile); err != nil {
+		log.Println(err.Error())
+		return
+	}
+	if err = os.Remove(client.metadataPath(sourcePath)); os.IsNotExist(err) {
+		err = nil
+	}
+	log.Println("Moved", sourcePath, "to", targetPath)
+	return
+}
+
+func (client FilesystemClient) UploadFile(filepath string, targetName string) (name string, checksum string, err error) {
+
+	if err = client.populate(); err != nil {
+		return
+	}
+
+	targetFile, err := client.filePath(targetName)
+	if err != nil {
+		return
+	}
+
+	stream, err := OpenUpload(filepath, client.Compression, client.Encryption)
+	if err != nil {
+		return
+	}
+	defer stream.Close()
+
+	if err = copyAtomically(stream, targetFile); err != nil {
+		log.Println(err.Error())
+		return
+	}
+	if err = client.writeMetadata(targetName, stream.Metadata); err != nil {
+		log.Println(err.Error())
+		return
+	}
+	name, checksum = targetName, stream.Checksum
+	log.Println("File", filepath, "uploaded to", targetName)
+	return
+}
+
+func (client FilesystemClient) GetFile(remotePath string, localDir string) (downloadedFilePath string, err error) {
+
+	if err = client.populate(); err != nil {
+		return
+	}
+
+	sourceFile, err := client.filePath(remotePath)
+	if err != nil {
+		return
+	}
+
+	fileReader, err := os.Open(sourceFile)
+	if err != nil {
+		log.Println("Failed to download file", err)
+		return
+	}
+	defer fileReader.Close()
+
+	metadata, err := client.readMetadata(remotePath)
+	if err != nil {
+		return
+	}
+
+	downloadedFilePath, err = DownloadTo(localDir, remotePath, client.OnConflict, func(file *os.File) (copyErr error) {
+		_, copyErr = io.Copy(file, fileReader)
+		return
+	}, func(key string) string {
+		return metadata[key]
+	}, client.Encryption)
+	if err != nil {
+		if err != ErrSkipped {
+			log.Println("Failed to download file", err)
+		}
+		return
+	}
+
+	log.Println("Downloaded ", remotePath, "to", downloadedFilePath)
+	return
+}
+
+func (client FilesystemClient) CreateClientUser() (credentials IaaSCredentials, err error) {
+
+	if err = client.populate(); err != nil {
+		return
+	}
+
+	userFile := client.clientUserPath()
+	if exists(userFile) {
+		err = errors.New("Client account already exists: " + client.ClientId)
+		return
+	}
+
+	secret := make([]byte, 20)
+	if _, err = rand.Read(secret); err != nil {
+		return
+	}
+	creds := FilesystemCredentials{ClientId: client.ClientId, SecretKey: hex.EncodeToString(secret)}
+
+	contents, err := json.Marshal(creds)
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(userFile), 0700); err != nil {
+		return
+	}
+	if err = ioutil.WriteFile(userFile, contents, 0600); err != nil {
+		return
+	}
+	if err = os.MkdirAll(client.clientDir(), 0755); err != nil {
+		return
+	}
+
+	credentials = creds
+	log.Println("Created client user account for " + client.ClientId)
+	return
+}
+
+func (client FilesystemClient) DeleteClientUser(force bool) (wasPreExisting bool, err error) {
+
+	if err = client.populate(); err != nil {
+		return
+	}
+
+	userFile := client.clientUserPath()
+	wasPreExisting = exists(userFile)
+
+	if force {
+		var files []string
+		files, err = client.ListFiles()
+		if err != nil {
+			return
+		}
+		if _, err = client.DeleteFiles(files); err != nil {
+			return
+		}
+	}
+
+	if !wasPreExisting {
+		return
+	}
+
+	err = os.Remove(userFile)
+	return
+}
+
+func (client FilesystemClient) AccountDetails() (details IaaSAccountDetails, err error) {
+
+	if client.IntegratorId == "" {
+		return nil, errors.New("You must specify an integrator for the filesystem backend")
+	}
+
+	root, err := filepath.Abs(client.Root)
+	if err != nil {
+		return
+	}
+	if !exists(root) {
+		return nil, errors.New("Filesystem root does not exist: " + root)
+	}
+
+	details = map[string]string{}
+	details["AccountId"] = root
+	details["IntegratorId"] = client.IntegratorId
+	details["CredentialType"] = "integrator"
+	if client.ClientId != "" {
+		details["ClientId"] = client.ClientId
+	}
+	return
+}
+
+func (client FilesystemClient) populate() error {
+	if _, err := client.AccountDetails(); err != nil {
+		return err
+	}
+	if client.ClientId == "" {
+		return errors.New("You must specify a client for this operation")
+	}
+	return nil
+}
+
+func (client FilesystemClient) integratorDir() string {
+	return filepath.Join(client.Root, client.IntegratorId)
+}
+
+func (client FilesystemClient) clientDir() string {
+	return filepath.Join(client.integratorDir(), client.ClientId)
+}
+
+func (client FilesystemClient) clientUserPath() string {
+	return filepath.Join(client.integratorDir(), ".clients", client.ClientId+".json")
+}
+
+func (client FilesystemClient) notificationPath() string {
+	return filepath.Join(client.integratorDir(), ".notifications", client.ClientId)
+}
+
+// metadataPath is where the metadata of an uploaded file is kept, outside the client directory so that it is not listed
+func (client FilesystemClient) metadataPath(remotePath string) string {
+	return filepath.Join(client.integratorDir(), ".metadata", client.ClientId, filepath.FromSlash(path.Clean("/"+remotePath))+".json")
+}
+
+func (client FilesystemClient) writeMetadata(remotePath string, metadata map[string]string) (err error) {
+	contents, err := json.Marshal(metadata)
+	if err != nil {
+		return
+	}
+	return copyAtomically(bytes.NewReader(contents), client.metadataPath(remotePath))
+}
+
+// readMetadata gives the metadata of an uploaded file, which is empty for files uploaded before metadata was kept
+func (client FilesystemClient) readMetadata(remotePath string) (metadata map[string]string, err error) {
+	metadata = map[string]string{}
+	contents, err := ioutil.ReadFile(client.metadataPath(remotePath))
+	if os.IsNotExist(err) {
+		return metadata, nil
+	}
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(contents, &metadata)
+	return
+}
+
+// filePath maps a remote path onto the client directory, refusing paths that would escape it
+func (client FilesystemClient) filePath(remotePath string) (string, error) {
+	cleanPath := path.Clean("/" + remotePath)
+	if cleanPath == "/" || strings.HasSuffix(remotePath, "/") {
+		return "", errors.New("Invalid remote file path: " + remotePath)
+	}
+	return filepath.Join(client.clientDir(), filepath.FromSlash(cleanPath)), nil
+}
+
+// isPartialFile tells whether a file name is that of a temp file written by copyAtomically
+func isPartialFile(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.Contains(name, ".partial")
+}
+
+// copyAtomically writes to a temp file in the target directory & renames it into place,
+// so that a reader never sees a partially written file
+func copyAtomically(source io.Reader, target string) (err error) {
+	if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return
+	}
+	tempFile, err := ioutil.TempFile(filepath.Dir(target), "."+filepath.Base(target)+".partial")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tempFile.Close()
+			os.Remove(tempFile.Name())
+		}
+	}()
+
+	if _, err = io.Copy(tempFile, source); err != nil {
+		return
+	}
+	if err = tempFile.Close(); err != nil {
+		return
+	}
+	if err = os.Chmod(tempFile.Name(), 0644); err != nil {
+		return
+	}
+	err = os.Rename(tempFile.Name(), target)
+	return
+}
@@ -0,0 +1,285 @@
+package iaas_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/dhrapson/sched-load/iaas"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("The filesystem IaaS Client", func() {
+
+	var (
+		rootDir  string
+		fsClient FilesystemClient
+		status   bool
+		err      error
+	)
+
+	BeforeEach(func() {
+		rootDir, err = ioutil.TempDir("", "iaas-filesystem")
+		Ω(err).ShouldNot(HaveOccurred())
+		fsClient = FilesystemClient{Root: rootDir, IntegratorId: "myintegrator", ClientId: "myclient"}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(rootDir)
+	})
+
+	Context("when getting account status", func() {
+		It("reports the integrator and client", func() {
+			details, err := fsClient.AccountDetails()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(details["IntegratorId"]).Should(Equal("myintegrator"))
+			Ω(details["ClientId"]).Should(Equal("myclient"))
+			Ω(details["CredentialType"]).Should(Equal("integrator"))
+		})
+
+		It("requires an integrator", func() {
+			fsClient.IntegratorId = ""
+			_, err := fsClient.AccountDetails()
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("when running client operations without a client", func() {
+		It("throws an error", func() {
+			fsClient.ClientId = ""
+			_, err := fsClient.ListFiles()
+			Ω(err).Should(MatchError("You must specify a client for this operation"))
+		})
+	})
+
+	Context("when managing files", func() {
+		It("uploads, lists, downloads and deletes a file", func() {
+			name, checksum, err := fsClient.UploadFile("fixtures/test-file.csv", "INPUT/test-file.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(name).Should(Equal("INPUT/test-file.csv"))
+			Ω(checksum).Should(Equal("d3e883a63131eff319103ed5b6ffd2e8ef0e60d441e3ed69d78417efccb0d186"))
+			Ω(filepath.Join(rootDir, "myintegrator", "myclient", "INPUT", "test-file.csv")).Should(BeARegularFile())
+
+			names, err := fsClient.ListFiles()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(names).Should(Equal([]string{"INPUT/test-file.csv"}))
+
+			localDir := filepath.Join(rootDir, "downloads")
+			localFilePath, err := fsClient.GetFile("INPUT/test-file.csv", localDir)
+			Ω(err).ShouldNot(HaveOccurred())
+			contents, err := ioutil.ReadFile(localFilePath)
+			Ω(err).ShouldNot(HaveOccurred())
+			expectedContents, err := ioutil.ReadFile("fixtures/test-file.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(contents).Should(Equal(expectedContents))
+
+			status, err = fsClient.DeleteFile("INPUT/test-file.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(status).Should(BeTrue())
+
+			status, err = fsClient.DeleteFile("INPUT/test-file.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(status).Should(BeFalse())
+
+			names, err = fsClient.ListFiles()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(names).Should(BeEmpty())
+		})
+
+		It("stores the checksum of an uploaded file", func() {
+			_, _, err := fsClient.UploadFile("fixtures/test-file.csv", "INPUT/test-file.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			checksum, _, err := ChecksumFile("fixtures/test-file.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+			info, err := fsClient.StatFile("INPUT/test-file.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(info.Name).Should(Equal("INPUT/test-file.csv"))
+			Ω(info.Checksum).Should(Equal(checksum))
+
+			_, err = fsClient.DeleteFile("INPUT/test-file.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = fsClient.StatFile("INPUT/test-file.csv")
+			Ω(err).Should(HaveOccurred())
+			Ω(filepath.Join(rootDir, "myintegrator", ".metadata", "myclient", "INPUT", "test-file.csv.json")).ShouldNot(BeAnExistingFile())
+		})
+
+		It("moves a file along with its metadata", func() {
+			_, _, err := fsClient.UploadFile("fixtures/test-file.csv", "INPUT/test-file.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(fsClient.MoveFile("INPUT/test-file.csv", "PROCESSED/test-file.csv")).Should(Succeed())
+			Ω(fsClient.ListFiles()).Should(Equal([]string{"PROCESSED/test-file.csv"}))
+			checksum, _, _ := ChecksumFile("fixtures/test-file.csv")
+			info, err := fsClient.StatFile("PROCESSED/test-file.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(info.Checksum).Should(Equal(checksum))
+			Ω(filepath.Join(rootDir, "myintegrator", ".metadata", "myclient", "INPUT", "test-file.csv.json")).ShouldNot(BeAnExistingFile())
+
+			err = fsClient.MoveFile("INPUT/test-file.csv", "PROCESSED/test-file.csv")
+			Ω(err).Should(MatchError("NoSuchKey: The specified key does not exist: INPUT/test-file.csv"))
+		})
+
+		for _, compression := range []string{CompressionGzip, CompressionZstd} {
+			compression := compression
+
+			It("compresses an upload with "+compression+" & decompresses it on download", func() {
+				fsClient.Compression = compression
+				_, _, err := fsClient.UploadFile("fixtures/test-file.csv", "INPUT/test-file.csv")
+				Ω(err).ShouldNot(HaveOccurred())
+
+				fixture, err := os.Stat("fixtures/test-file.csv")
+				Ω(err).ShouldNot(HaveOccurred())
+				info, err := fsClient.StatFile("INPUT/test-file.csv")
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(info.Compression).Should(Equal(compression))
+				Ω(info.Size).Should(BeNumerically("<", fixture.Size()))
+				Ω(info.UncompressedSize).Should(Equal(fixture.Size()))
+
+				downloadDir := filepath.Join(rootDir, "downloads")
+				localPath, err := FilesystemClient{Root: rootDir, IntegratorId: "myintegrator", ClientId: "myclient"}.GetFile("INPUT/test-file.csv", downloadDir)
+				Ω(err).ShouldNot(HaveOccurred())
+				expected, _ := ioutil.ReadFile("fixtures/test-file.csv")
+				Ω(ioutil.ReadFile(localPath)).Should(Equal(expected))
+			})
+		}
+
+		It("rejects an unknown compression", func() {
+			fsClient.Compression = "lz4"
+			_, _, err := fsClient.UploadFile("fixtures/test-file.csv", "INPUT/test-file.csv")
+			Ω(err).Should(MatchError("Unknown compression lz4, expected gzip or zstd"))
+		})
+
+		It("lists clients and file details", func() {
+			_, _, err := fsClient.UploadFile("fixtures/test-file.csv", "INPUT/test-file.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+			_, _, err = fsClient.ForClient("otherclient").UploadFile("fixtures/test-file.csv", "INPUT/test-file.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = fsClient.AddFileUploadNotification()
+			Ω(err).ShouldNot(HaveOccurred())
+
+			clientIds, err := FilesystemClient{Root: rootDir, IntegratorId: "myintegrator"}.ListClients()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(clientIds).Should(Equal([]string{"myclient", "otherclient"}))
+
+			fixture, err := os.Stat("fixtures/test-file.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+			files, err := fsClient.ListFileInfos()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(files).Should(HaveLen(1))
+			Ω(files[0].Name).Should(Equal("INPUT/test-file.csv"))
+			Ω(files[0].Size).Should(Equal(fixture.Size()))
+			Ω(files[0].LastModified).Should(BeTemporally("~", time.Now(), time.Minute))
+			Ω(files[0].StorageClass).Should(Equal(StandardStorageClass))
+
+			// the ETag changes along with the file, & StatFile agrees with the listing
+			info, err := fsClient.StatFile("INPUT/test-file.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(info.ETag).Should(Equal(files[0].ETag))
+			_, _, err = fsClient.UploadFile("fixtures/test-file.csv", "INPUT/test-file.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+			replaced, err := fsClient.ListFileInfos()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(replaced[0].ETag).ShouldNot(Equal(files[0].ETag))
+		})
+
+		It("walks files in the order S3 lists them", func() {
+			for _, name := range []string{"INPUT/a/b.csv", "INPUT/a-b.csv", "INPUT/b.csv"} {
+				_, _, err := fsClient.UploadFile("fixtures/test-file.csv", name)
+				Ω(err).ShouldNot(HaveOccurred())
+			}
+
+			var names []string
+			err := fsClient.WalkFiles("INPUT/", func(file FileInfo) error {
+				names = append(names, file.Name)
+				return nil
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(names).Should(Equal([]string{"INPUT/a-b.csv", "INPUT/a/b.csv", "INPUT/b.csv"}))
+		})
+
+		It("aborts interrupted uploads, which are not listed", func() {
+			partialFile := filepath.Join(rootDir, "myintegrator", "myclient", "INPUT", ".test-file.csv.partial123")
+			Ω(os.MkdirAll(filepath.Dir(partialFile), 0755)).Should(Succeed())
+			Ω(ioutil.WriteFile(partialFile, []byte("a,b"), 0644)).Should(Succeed())
+
+			names, err := fsClient.ListFiles()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(names).Should(BeEmpty())
+
+			names, err = fsClient.AbortIncompleteUploads(time.Now().Add(-time.Hour))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(names).Should(BeEmpty())
+
+			names, err = fsClient.AbortIncompleteUploads(time.Now().Add(time.Minute))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(names).Should(Equal([]string{"INPUT/.test-file.csv.partial123"}))
+			Ω(partialFile).ShouldNot(BeAnExistingFile())
+		})
+
+		It("keeps paths within the client directory", func() {
+			_, _, err := fsClient.UploadFile("fixtures/test-file.csv", "../otherclient/INPUT/test-file.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(filepath.Join(rootDir, "myintegrator", "otherclient")).ShouldNot(BeADirectory())
+			Ω(filepath.Join(rootDir, "myintegrator", "myclient", "otherclient", "INPUT", "test-file.csv")).Should(BeARegularFile())
+		})
+
+		It("throws an error when downloading a missing file", func() {
+			_, err := fsClient.GetFile("INPUT/missing.csv", rootDir)
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("when managing upload notifications", func() {
+		It("adds, finds and removes the notification", func() {
+			status, err = fsClient.AddFileUploadNotification()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(status).Should(BeTrue())
+
+			status, err = fsClient.AddFileUploadNotification()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(status).Should(BeFalse())
+
+			status, err = fsClient.FileUploadNotification()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(status).Should(BeTrue())
+
+			status, err = fsClient.RemoveFileUploadNotification()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(status).Should(BeTrue())
+
+			status, err = fsClient.FileUploadNotification()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(status).Should(BeFalse())
+		})
+	})
+
+	Context("when managing client users", func() {
+		It("creates the user and deletes it along with its files", func() {
+			credentials, err := fsClient.CreateClientUser()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(credentials.Map()["ClientId"]).Should(Equal("myclient"))
+			Ω(credentials.Map()["SecretKey"]).ShouldNot(BeEmpty())
+
+			_, err = fsClient.CreateClientUser()
+			Ω(err).Should(HaveOccurred())
+
+			_, _, err = fsClient.UploadFile("fixtures/test-file.csv", "INPUT/test-file.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			status, err = fsClient.DeleteClientUser(true)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(status).Should(BeTrue())
+
+			names, err := fsClient.ListFiles()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(names).Should(BeEmpty())
+
+			status, err = fsClient.DeleteClientUser(false)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(status).Should(BeFalse())
+		})
+	})
+})
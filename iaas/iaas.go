@@ -1,13 +1,20 @@
 package iaas
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
-	"path"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -25,17 +32,76 @@ type IaaSCredentials interface {
 	Map() map[string]string
 }
 
+// StandardStorageClass is the storage class of files not stored in another, which S3 leaves out of HeadObject
+const StandardStorageClass = "STANDARD"
+
+// FileInfo describes an uploaded file, with its Name relative to the client's upload area.
+// Checksum is the hex SHA-256 of the file as uploaded, before any compression, which is only given by StatFile.
+// ETag changes whenever the stored file does, though it is only an MD5 of the file for single-part S3 uploads.
+type FileInfo struct {
+	Name         string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+	StorageClass string
+	Checksum     string
+	// Compression & EncryptionKeyId are set for a file compressed or encrypted on upload,
+	// along with the UncompressedSize of the original file
+	Compression      string
+	EncryptionKeyId  string
+	UncompressedSize int64
+	// ServerSideEncryption & SSEKMSKeyId are given by StatFile, as the encryption S3 reports
+	ServerSideEncryption string
+	SSEKMSKeyId          string
+	// UploadedAt is given by StatFile, as when the file arrived. Unlike LastModified it is kept when the file
+	// is moved, e.g. from INPUT/ to PROCESSED/.
+	UploadedAt time.Time
+}
+
+// WalkFunc is called by WalkFiles for each file in turn. Returning StopWalk ends the walk without error,
+// & returning any other error ends the walk with it.
+type WalkFunc func(file FileInfo) error
+
+// StopWalk is returned by a WalkFunc to stop walking files, e.g. once it has seen enough of them
+var StopWalk = errors.New("stop walking files")
+
+// IsNotFound tells whether an error from StatFile means that the file does not exist
+func IsNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	if os.IsNotExist(err) {
+		return true
+	}
+	message := err.Error()
+	if awsErr, ok := err.(awserr.Error); ok {
+		message = awsErr.Code()
+	}
+	return strings.HasPrefix(message, "NotFound") || strings.HasPrefix(message, "NoSuchKey")
+}
+
 type IaaSClient interface {
 	DeleteFile(remotePath string) (wasPreExisting bool, err error)
+	DeleteFiles(remotePaths []string) (deleted []string, err error)
+	MoveFile(sourcePath string, targetPath string) (err error)
 	GetFile(remotePath string, localDir string) (downloadedFilePath string, err error)
 	ListFiles() (names []string, err error)
-	UploadFile(filepath string, target string) (name string, err error)
+	ListFileInfos() (files []FileInfo, err error)
+	WalkFiles(prefix string, walkFn WalkFunc) (err error)
+	StatFile(remotePath string) (info FileInfo, err error)
+	UploadFile(filepath string, target string) (name string, checksum string, err error)
 	AddFileUploadNotification() (wasNewConfiguration bool, err error)
 	FileUploadNotification() (isSet bool, err error)
 	RemoveFileUploadNotification() (wasPreExisting bool, err error)
 	CreateClientUser() (credentials IaaSCredentials, err error)
 	DeleteClientUser(force bool) (wasPreExisting bool, err error)
 	AccountDetails() (details IaaSAccountDetails, err error)
+	ListClients() (clientIds []string, err error)
+	ForClient(clientId string) IaaSClient
+	WithoutEncoding() IaaSClient
+	AbortIncompleteUploads(initiatedBefore time.Time) (names []string, err error)
+	EncryptionStatus() (description string, err error)
+	PutLifecycleRules(rules []LifecycleRule) (supported bool, err error)
 }
 
 type AwsClient struct {
@@ -44,6 +110,35 @@ type AwsClient struct {
 	IntegratorId string
 	ClientId     string
 	AccountId    string
+
+	// Endpoint, PathStyle, InsecureSkipVerify & CABundle connect to S3-compatible stores, e.g. MinIO
+	Endpoint           string
+	PathStyle          bool
+	InsecureSkipVerify bool
+	CABundle           string
+
+	// PartSize & Concurrency tune multipart uploads, with zero giving 5MB parts 5 at a time
+	PartSize    int64
+	Concurrency int
+	// StateDir saves the progress of multipart uploads so that they can be resumed
+	StateDir    string
+	Compression string
+	Encryption  KeyWrapper
+
+	// ServerSideEncryption is none, AES256, aws:kms or bucket-default, with KMSKeyId choosing the aws:kms key
+	ServerSideEncryption string
+	KMSKeyId             string
+
+	// OnConflict is what GetFile does when the local file exists: overwrite, skip or rename
+	OnConflict string
+
+	// Credentials is env, shared or, if unset, the SDK's usual chain
+	Credentials        string
+	CredentialsProfile string
+	Proxy              string
+
+	// DisableSDKRetries leaves retrying to a wrapping RetryingClient
+	DisableSDKRetries bool
 }
 
 type AwsCredentials struct {
@@ -173,6 +268,32 @@ func (client AwsClient) AddFileUploadNotification() (wasNewConfiguration bool, e
 func (client AwsClient) ListFiles() (names []string, err error) {
 	names = []string{}
 
+	files, err := client.ListFileInfos()
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	return
+}
+
+func (client AwsClient) ListFileInfos() (files []FileInfo, err error) {
+	files = []FileInfo{}
+	err = client.WalkFiles("", func(file FileInfo) error {
+		files = append(files, file)
+		return nil
+	})
+	return
+}
+
+// listPageSize is the most objects S3 gives in one page of a listing
+const listPageSize = 1000
+
+// WalkFiles gives the client's files whose names start with the prefix, in order, a page of the listing at a time,
+// so that a client with any number of files can be walked without holding them all
+func (client AwsClient) WalkFiles(prefix string, walkFn WalkFunc) (err error) {
+
 	if err = client.populate(); err != nil {
 		return
 	}
@@ -182,49 +303,206 @@ func (client AwsClient) ListFiles() (names []string, err error) {
 		return
 	}
 
-	svc := s3.New(session)
+	svc := s3.New(session, client.s3Config())
 
-	params := &s3.ListObjectsInput{
-		Bucket: aws.String(client.bucketName()),
-		Prefix: aws.String(client.ClientId),
+	clientPrefix := client.ClientId + "/"
+	params := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(client.bucketName()),
+		Prefix:  aws.String(clientPrefix + prefix),
+		MaxKeys: aws.Int64(listPageSize),
+	}
+	for {
+		resp, listErr := svc.ListObjectsV2(params)
+		if listErr != nil {
+			log.Println(listErr.Error())
+			return listErr
+		}
+
+		for _, key := range resp.Contents {
+			err = walkFn(FileInfo{
+				Name:         strings.TrimPrefix(aws.StringValue(key.Key), clientPrefix),
+				Size:         aws.Int64Value(key.Size),
+				LastModified: aws.TimeValue(key.LastModified),
+				ETag:         eTag(key.ETag),
+				StorageClass: storageClass(key.StorageClass),
+			})
+			if err == StopWalk {
+				return nil
+			}
+			if err != nil {
+				return
+			}
+		}
+
+		if !aws.BoolValue(resp.IsTruncated) {
+			return
+		}
+		params.ContinuationToken = resp.NextContinuationToken
 	}
-	resp, err := svc.ListObjects(params)
+}
 
+// StatFile describes an uploaded file, including the checksum stored with it on upload
+func (client AwsClient) StatFile(remotePath string) (info FileInfo, err error) {
+
+	if err = client.populate(); err != nil {
+		return
+	}
+
+	session, err := client.connect()
 	if err != nil {
-		log.Println(err.Error())
 		return
 	}
 
-	for _, key := range resp.Contents {
-		objectPath := *key.Key
-		objectName := strings.Join(strings.Split(objectPath, "/")[1:], "/")
-		names = append(names, objectName)
+	svc := s3.New(session, client.s3Config())
+
+	params := &s3.HeadObjectInput{
+		Bucket: aws.String(client.bucketName()),
+		Key:    aws.String(client.ClientId + "/" + remotePath),
+	}
+	resp, err := svc.HeadObject(params)
+
+	if err != nil {
+		if !IsNotFound(err) {
+			log.Println(err.Error())
+		}
+		return
 	}
+
+	info = FileInfoFromMetadata(remotePath, aws.Int64Value(resp.ContentLength), aws.TimeValue(resp.LastModified), func(key string) string {
+		return metadataValue(resp.Metadata, key)
+	})
+	info.ETag = eTag(resp.ETag)
+	info.StorageClass = storageClass(resp.StorageClass)
+	info.ServerSideEncryption = aws.StringValue(resp.ServerSideEncryption)
+	info.SSEKMSKeyId = aws.StringValue(resp.SSEKMSKeyId)
 	return
 }
 
-func (client AwsClient) DeleteFile(remotePath string) (wasPreExisting bool, err error) {
+// ListClients gives the clients with an upload area in the integrator's bucket
+func (client AwsClient) ListClients() (clientIds []string, err error) {
+	clientIds = []string{}
+
+	if err = client.populateIntegrator(); err != nil {
+		return
+	}
+
+	session, err := client.connect()
+	if err != nil {
+		return
+	}
+
+	svc := s3.New(session, client.s3Config())
+
+	params := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(client.bucketName()),
+		Delimiter: aws.String("/"),
+		MaxKeys:   aws.Int64(listPageSize),
+	}
+	for {
+		resp, listErr := svc.ListObjectsV2(params)
+		if listErr != nil {
+			log.Println(listErr.Error())
+			return clientIds, listErr
+		}
+
+		for _, prefix := range resp.CommonPrefixes {
+			clientIds = append(clientIds, strings.TrimSuffix(*prefix.Prefix, "/"))
+		}
+
+		if !aws.BoolValue(resp.IsTruncated) {
+			return
+		}
+		params.ContinuationToken = resp.NextContinuationToken
+	}
+}
+
+func (client AwsClient) ForClient(clientId string) IaaSClient {
+	client.ClientId = clientId
+	return client
+}
+
+// WithoutEncoding gives a client that uploads & downloads files as they are, without compression or client-side
+// encryption, for the documents that must stay readable by the integrator, e.g. the schedule
+func (client AwsClient) WithoutEncoding() IaaSClient {
+	client.Compression, client.Encryption = "", nil
+	return client
+}
+
+// AbortIncompleteUploads aborts the client's multipart uploads started before the given time,
+// which would otherwise be kept, & charged for, indefinitely
+func (client AwsClient) AbortIncompleteUploads(initiatedBefore time.Time) (names []string, err error) {
+	names = []string{}
 
 	if err = client.populate(); err != nil {
 		return
 	}
 
-	files, err := client.ListFiles()
+	session, err := client.connect()
 	if err != nil {
 		return
 	}
-	if !arrayContains(files, remotePath) {
+
+	svc := s3.New(session, client.s3Config())
+
+	params := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(client.bucketName()),
+		Prefix: aws.String(client.ClientId + "/"),
+	}
+	for {
+		resp, listErr := svc.ListMultipartUploads(params)
+		if listErr != nil {
+			log.Println(listErr.Error())
+			return names, listErr
+		}
+
+		for _, upload := range resp.Uploads {
+			if !aws.TimeValue(upload.Initiated).Before(initiatedBefore) {
+				continue
+			}
+			_, err = svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(client.bucketName()),
+				Key:      upload.Key,
+				UploadId: upload.UploadId,
+			})
+			if err != nil {
+				log.Println(err.Error())
+				return
+			}
+			names = append(names, strings.TrimPrefix(*upload.Key, client.ClientId+"/"))
+		}
+
+		if !aws.BoolValue(resp.IsTruncated) {
+			return
+		}
+		params.KeyMarker = resp.NextKeyMarker
+		params.UploadIdMarker = resp.NextUploadIdMarker
+	}
+}
+
+func (client AwsClient) DeleteFile(remotePath string) (wasPreExisting bool, err error) {
+
+	if err = client.populate(); err != nil {
 		return
 	}
 
-	wasPreExisting = true
+	// only the files starting with the path are listed, however many the client has
+	err = client.WalkFiles(remotePath, func(file FileInfo) error {
+		if file.Name == remotePath {
+			wasPreExisting = true
+			return StopWalk
+		}
+		return nil
+	})
+	if err != nil || !wasPreExisting {
+		return
+	}
 
 	session, err := client.connect()
 	if err != nil {
 		return
 	}
 
-	svc := s3.New(session)
+	svc := s3.New(session, client.s3Config())
 
 	targetFile := client.ClientId + "/" + remotePath
 
@@ -243,7 +521,151 @@ func (client AwsClient) DeleteFile(remotePath string) (wasPreExisting bool, err
 	return
 }
 
-func (client AwsClient) UploadFile(filepath string, targetName string) (name string, err error) {
+// deleteBatchSize is the most objects S3 deletes in one request
+const deleteBatchSize = 1000
+
+// DeleteFiles deletes files in batches with multi-object deletes, giving those deleted. S3 deletes every file it can
+// in a batch, so on error the files deleted so far are still given.
+func (client AwsClient) DeleteFiles(remotePaths []string) (deleted []string, err error) {
+	deleted = []string{}
+
+	if err = client.populate(); err != nil {
+		return
+	}
+
+	session, err := client.connect()
+	if err != nil {
+		return
+	}
+
+	svc := s3.New(session, client.s3Config())
+
+	clientPrefix := client.ClientId + "/"
+	for start := 0; start < len(remotePaths); start += deleteBatchSize {
+		end := start + deleteBatchSize
+		if end > len(remotePaths) {
+			end = len(remotePaths)
+		}
+		objects := []*s3.ObjectIdentifier{}
+		for _, remotePath := range remotePaths[start:end] {
+			objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(clientPrefix + remotePath)})
+		}
+
+		resp, deleteErr := svc.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(client.bucketName()),
+			Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(false)},
+		})
+		if deleteErr != nil {
+			log.Println(deleteErr.Error())
+			return deleted, deleteErr
+		}
+
+		for _, object := range resp.Deleted {
+			deleted = append(deleted, strings.TrimPrefix(aws.StringValue(object.Key), clientPrefix))
+		}
+		if len(resp.Errors) > 0 {
+			first := resp.Errors[0]
+			err = fmt.Errorf("Unable to delete %d files, e.g. %s: %s: %s", len(resp.Errors),
+				strings.TrimPrefix(aws.StringValue(first.Key), clientPrefix), aws.StringValue(first.Code), aws.StringValue(first.Message))
+			log.Println(err.Error())
+			return
+		}
+	}
+	return
+}
+
+// MoveFile copies a file to another path in the client's upload area, with its metadata, then deletes the original
+func (client AwsClient) MoveFile(sourcePath string, targetPath string) (err error) {
+
+	if err = client.populate(); err != nil {
+		return
+	}
+	if err = ValidateServerSideEncryption(client.ServerSideEncryption, client.KMSKeyId); err != nil {
+		return
+	}
+
+	session, err := client.connect()
+	if err != nil {
+		return
+	}
+	svc := s3.New(session, client.s3Config())
+
+	sourceKey := client.ClientId + "/" + sourcePath
+	source, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(client.bucketName()),
+		Key:    aws.String(sourceKey),
+	})
+	if err != nil {
+		log.Println("Failed to move file", err)
+		return
+	}
+
+	// the copy is given when the file arrived, as it is last modified when copied, so its metadata is replaced,
+	// carrying over the rest of the metadata & headers
+	metadata := map[string]*string{}
+	for key, value := range source.Metadata {
+		metadata[key] = value
+	}
+	if metadataValue(metadata, UploadedAtMetadataKey) == "" {
+		metadata[UploadedAtMetadataKey] = aws.String(formatUploadedAt(aws.TimeValue(source.LastModified)))
+	}
+
+	// the copy source is URL-encoded, & keeps the encryption of new uploads as S3 does not carry it over
+	copySource := (&url.URL{Path: client.bucketName() + "/" + sourceKey}).EscapedPath()
+	sse, kmsKeyId := client.serverSideEncryption()
+	if size := aws.Int64Value(source.ContentLength); size > MaxCopySize {
+		err = multipartCopy(svc, &s3.CreateMultipartUploadInput{
+			Bucket:               aws.String(client.bucketName()),
+			Key:                  aws.String(client.ClientId + "/" + targetPath),
+			Metadata:             metadata,
+			CacheControl:         source.CacheControl,
+			ContentDisposition:   source.ContentDisposition,
+			ContentEncoding:      source.ContentEncoding,
+			ContentLanguage:      source.ContentLanguage,
+			ContentType:          source.ContentType,
+			StorageClass:         source.StorageClass,
+			ServerSideEncryption: sse,
+			SSEKMSKeyId:          kmsKeyId,
+		}, copySource, size, copyPartSize)
+	} else {
+		_, err = svc.CopyObject(&s3.CopyObjectInput{
+			Bucket:               aws.String(client.bucketName()),
+			CopySource:           aws.String(copySource),
+			Key:                  aws.String(client.ClientId + "/" + targetPath),
+			MetadataDirective:    aws.String("REPLACE"),
+			Metadata:             metadata,
+			CacheControl:         source.CacheControl,
+			ContentDisposition:   source.ContentDisposition,
+			ContentEncoding:      source.ContentEncoding,
+			ContentLanguage:      source.ContentLanguage,
+			ContentType:          source.ContentType,
+			StorageClass:         source.StorageClass,
+			ServerSideEncryption: sse,
+			SSEKMSKeyId:          kmsKeyId,
+		})
+	}
+	if err != nil {
+		log.Println("Failed to move file", err)
+		return
+	}
+
+	_, err = svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(client.bucketName()),
+		Key:    aws.String(sourceKey),
+	})
+	if err != nil {
+		log.Println("Failed to move file", err)
+		return
+	}
+	log.Println("Moved", sourcePath, "to", targetPath)
+	return
+}
+
+func (client AwsClient) UploadFile(filepath string, targetName string) (name string, checksum string, err error) {
+
+	if err = client.validateUploadOptions(); err != nil {
+		return
+	}
 
 	if err = client.populate(); err != nil {
 		return
@@ -256,80 +678,135 @@ func (client AwsClient) UploadFile(filepath string, targetName string) (name str
 		return
 	}
 
-	svc := s3.New(session)
+	svc := s3.New(session, client.s3Config())
 
-	fileReader, err := os.Open(filepath)
+	stream, err := OpenUpload(filepath, client.Compression, client.Encryption)
 	if err != nil {
 		return
 	}
-	defer fileReader.Close()
+	defer stream.Close()
+	metadata := aws.StringMap(stream.Metadata)
+
+	sse, kmsKeyId := client.serverSideEncryption()
+
+	partSize := client.partSizeFor(stream.Size)
+	if client.StateDir != "" && stream.Size > partSize && !stream.Encoded {
+		upload := resumableUpload{
+			svc:                  svc,
+			stateDir:             client.StateDir,
+			bucket:               client.bucketName(),
+			key:                  targetFile,
+			localPath:            filepath,
+			partSize:             partSize,
+			concurrency:          client.Concurrency,
+			serverSideEncryption: sse,
+			kmsKeyId:             kmsKeyId,
+			metadata:             metadata,
+		}
+		if err = upload.run(); err != nil {
+			log.Println(err.Error())
+			return
+		}
+		name, checksum = targetName, stream.Checksum
+		log.Println("File", filepath, "uploaded to", targetName)
+		return
+	}
 
-	encType := "AES256"
+	// the uploader streams the file in parts, in parallel, switching to a single PutObject for small files
+	uploader := s3manager.NewUploaderWithClient(svc, func(uploader *s3manager.Uploader) {
+		if client.PartSize > 0 {
+			uploader.PartSize = client.PartSize
+		}
+		if client.Concurrency > 0 {
+			uploader.Concurrency = client.Concurrency
+		}
+	})
 
-	params := &s3.PutObjectInput{
+	params := &s3manager.UploadInput{
 		Bucket:               aws.String(client.bucketName()),
 		Key:                  aws.String(targetFile),
-		Body:                 fileReader,
-		ServerSideEncryption: &encType,
+		Body:                 stream,
+		Metadata:             metadata,
+		ServerSideEncryption: sse,
+		SSEKMSKeyId:          kmsKeyId,
+	}
+	// S3 checks the Content-MD5 of files small enough to be sent in a single request, multipart uploads send one per part.
+	// An encoded file is streamed, so its MD5 is not known up front. The Content-Encoding is only given when the file
+	// is compressed but not encrypted, as HTTP clients could not otherwise decode it.
+	if !stream.Encoded && stream.Size < partSize {
+		params.ContentMD5 = aws.String(stream.ContentMD5)
+	}
+	if client.Compression != "" && client.Encryption == nil {
+		params.ContentEncoding = aws.String(client.Compression)
 	}
 
-	_, err = svc.PutObject(params)
+	_, err = uploader.Upload(params)
 
 	if err != nil {
 		log.Println(err.Error())
 		return
 	}
-	name = targetName
+	name, checksum = targetName, stream.Checksum
 	log.Println("File", filepath, "uploaded to", targetName)
 	return
 }
 
+// GetFile downloads a file to its path beneath the local directory, e.g. INPUT/extract.csv to <dir>/INPUT/extract.csv
 func (client AwsClient) GetFile(remotePath string, localDir string) (downloadedFilePath string, err error) {
 
 	if err = client.populate(); err != nil {
 		return
 	}
-
-	name := path.Base(remotePath)
-	downloadedFilePath = path.Join(localDir, name)
-	targetFile := client.ClientId + "/" + name
-
-	if !exists(localDir) {
-		err = os.MkdirAll(localDir, 0722)
-		if err != nil {
-			return
-		}
+	if err = ValidateConflictPolicy(client.OnConflict); err != nil {
+		return
 	}
 
-	file, err := os.Create(downloadedFilePath)
+	session, err := client.connect()
 	if err != nil {
-		log.Fatal("Failed to create file", err)
+		return
 	}
-	defer file.Close()
 
-	session, err := client.connect()
+	targetFile := client.ClientId + "/" + remotePath
+	svc := s3.New(session, client.s3Config())
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(client.bucketName()),
+		Key:    aws.String(targetFile),
+	})
 	if err != nil {
+		log.Println("Failed to download file", err)
 		return
 	}
 
-	downloader := s3manager.NewDownloader(session)
-	numBytes, err := downloader.Download(file,
-		&s3.GetObjectInput{
-			Bucket: aws.String(client.bucketName()),
-			Key:    aws.String(targetFile),
-		})
+	// the downloader fetches ranges of the file, which Go's HTTP client leaves as stored
+	var numBytes int64
+	downloader := s3manager.NewDownloaderWithClient(svc)
+	downloadedFilePath, err = DownloadTo(localDir, remotePath, client.OnConflict, func(file *os.File) (downloadErr error) {
+		numBytes, downloadErr = downloader.Download(file,
+			&s3.GetObjectInput{
+				Bucket: aws.String(client.bucketName()),
+				Key:    aws.String(targetFile),
+			})
+		return
+	}, func(key string) string {
+		return metadataValue(head.Metadata, key)
+	}, client.Encryption)
 	if err != nil {
-		log.Println("Failed to download file", err)
+		if err != ErrSkipped {
+			log.Println("Failed to download file", err)
+		}
 		return
 	}
 
-	log.Println("Downloaded ", remotePath, "to", file.Name(), "size", numBytes, "bytes")
+	log.Println("Downloaded ", remotePath, "to", downloadedFilePath, "size", numBytes, "bytes")
 
 	return
 }
 
 func (client AwsClient) CreateClientUser() (credentials IaaSCredentials, err error) {
 
+	if client.Endpoint != "" {
+		return nil, errNoClientUsers
+	}
 	if err = client.populate(); err != nil {
 		return
 	}
@@ -349,6 +826,9 @@ func (client AwsClient) CreateClientUser() (credentials IaaSCredentials, err err
 
 func (client AwsClient) DeleteClientUser(force bool) (wasPreExisting bool, err error) {
 
+	if client.Endpoint != "" {
+		return false, errNoClientUsers
+	}
 	if err = client.populate(); err != nil {
 		return
 	}
@@ -364,11 +844,8 @@ func (client AwsClient) DeleteClientUser(force bool) (wasPreExisting bool, err e
 		if err != nil {
 			return
 		}
-		for _, file := range files {
-			_, err = client.DeleteFile(file)
-			if err != nil {
-				return
-			}
+		if _, err = client.DeleteFiles(files); err != nil {
+			return
 		}
 	}
 
@@ -409,6 +886,10 @@ func (client AwsClient) DeleteClientUser(force bool) (wasPreExisting bool, err e
 func (client AwsClient) AccountDetails() (details IaaSAccountDetails, err error) {
 
 	details = map[string]string{}
+	if client.Endpoint != "" {
+		return client.endpointAccountDetails()
+	}
+
 	session, err := client.connect()
 	if err != nil {
 		return
@@ -447,7 +928,34 @@ func (client AwsClient) AccountDetails() (details IaaSAccountDetails, err error)
 	return
 }
 
+// endpointAccountDetails describes an S3-compatible store, which has no IAM to identify the credentials
+// errNoClientUsers keeps IAM, which belongs to AWS rather than the store, out of reach with a custom endpoint
+var errNoClientUsers = errors.New("Client users are not supported with a custom endpoint")
+
+func (client AwsClient) endpointAccountDetails() (details IaaSAccountDetails, err error) {
+	if client.IntegratorId == "" {
+		return nil, errors.New("You must specify an integrator when using a custom endpoint")
+	}
+	details = map[string]string{}
+	details["AccountId"] = client.Endpoint
+	details["IntegratorId"] = client.IntegratorId
+	details["CredentialType"] = "s3-compatible"
+	err = client.syncVariables(details)
+	return
+}
+
 func (client *AwsClient) populate() error {
+	if err := client.populateIntegrator(); err != nil {
+		return err
+	}
+	if client.ClientId == "" {
+		return errors.New("You must specify a client for this operation")
+	}
+	return nil
+}
+
+// populateIntegrator looks up the integrator, for operations across all of its clients
+func (client *AwsClient) populateIntegrator() error {
 	if client.IntegratorId == "" || client.AccountId == "" {
 		details, err := client.AccountDetails()
 		if err != nil {
@@ -458,9 +966,6 @@ func (client *AwsClient) populate() error {
 			return err
 		}
 	}
-	if client.ClientId == "" {
-		return errors.New("You must specify a client for this operation")
-	}
 	return nil
 }
 
@@ -701,7 +1206,7 @@ func (client AwsClient) getUploadNotificationConfiguration() (config *s3.Notific
 		return
 	}
 
-	svc := s3.New(session)
+	svc := s3.New(session, client.s3Config())
 
 	params := &s3.GetBucketNotificationConfigurationRequest{
 		Bucket: aws.String(client.bucketName()),
@@ -723,10 +1228,10 @@ func (client AwsClient) putUploadNotificationConfiguration(config *s3.Notificati
 		return
 	}
 
-	svc := s3.New(session)
+	svc := s3.New(session, client.s3Config())
 
 	params := &s3.PutBucketNotificationConfigurationInput{
-		Bucket: aws.String(client.bucketName()),
+		Bucket:                    aws.String(client.bucketName()),
 		NotificationConfiguration: config,
 	}
 	_, err = svc.PutBucketNotificationConfiguration(params)
@@ -748,13 +1253,108 @@ func (client AwsClient) getNotificationPrefix() string {
 }
 
 func (client AwsClient) bucketName() string {
+	if client.Endpoint != "" {
+		return client.IntegratorId
+	}
+	// the leading slash keeps AWS requests path-style, PathStyle does this explicitly for other stores
 	return "/" + client.IntegratorId
 }
 
+// partSizeFor gives the part size for a file, raised from the configured size if the file would need too many parts
+func (client AwsClient) partSizeFor(size int64) int64 {
+	partSize := client.PartSize
+	if partSize == 0 {
+		partSize = s3manager.DefaultUploadPartSize
+	}
+	if minimum := (size + s3manager.MaxUploadParts - 1) / s3manager.MaxUploadParts; partSize < minimum {
+		partSize = minimum
+	}
+	return partSize
+}
+
+func (client AwsClient) validateUploadOptions() error {
+	if client.PartSize != 0 && client.PartSize < s3manager.MinUploadPartSize {
+		return errors.New("Part size must be at least 5MB")
+	}
+	if client.Concurrency < 0 {
+		return errors.New("Concurrency must not be negative")
+	}
+	if err := ValidateServerSideEncryption(client.ServerSideEncryption, client.KMSKeyId); err != nil {
+		return err
+	}
+	return ValidateCompression(client.Compression)
+}
+
+func (client AwsClient) s3Config() *aws.Config {
+	config := &aws.Config{}
+	if client.Endpoint != "" {
+		config.Endpoint = aws.String(client.Endpoint)
+	}
+	if client.PathStyle {
+		config.S3ForcePathStyle = aws.Bool(true)
+	}
+	return config
+}
+
+func (client AwsClient) httpClient() (httpClient *http.Client, err error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: client.InsecureSkipVerify}
+
+	if client.CABundle != "" {
+		var pem []byte
+		pem, err = ioutil.ReadFile(client.CABundle)
+		if err != nil {
+			return
+		}
+		// add to, rather than replace, the system roots so that IAM calls to AWS still verify
+		pool, poolErr := x509.SystemCertPool()
+		if poolErr != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			err = errors.New("No certificates found in CA bundle: " + client.CABundle)
+			return
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if client.Proxy != "" {
+		var proxyURL *url.URL
+		if proxyURL, err = ParseProxy(client.Proxy); err != nil {
+			return
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	httpClient = &http.Client{
+		Transport: &http.Transport{
+			Proxy:           proxy,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+	return
+}
+
 func (client AwsClient) connect() (sess *session.Session, err error) {
-	sess, err = session.NewSession(&aws.Config{
+	config := &aws.Config{
 		Region: aws.String(client.Region),
-	})
+	}
+	if client.DisableSDKRetries {
+		config.MaxRetries = aws.Int(0)
+	}
+	if config.Credentials, err = client.credentials(); err != nil {
+		log.Println("Failed to connect:", err)
+		return
+	}
+	if client.InsecureSkipVerify || client.CABundle != "" || client.Proxy != "" {
+		config.HTTPClient, err = client.httpClient()
+		if err != nil {
+			log.Println("Failed to connect:", err)
+			return
+		}
+	}
+
+	sess, err = session.NewSession(config)
 
 	if err != nil {
 		log.Println("Failed to connect:", err)
@@ -777,12 +1377,3 @@ func exists(path string) bool {
 
 	return true
 }
-
-func arrayContains(haystack []string, needle string) bool {
-	for _, hay := range haystack {
-		if needle == hay {
-			return true
-		}
-	}
-	return false
-}
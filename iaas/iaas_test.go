@@ -22,6 +22,7 @@ var (
 	clientName                string
 	clientCreds               IaaSCredentials
 	err                       error
+	awsAvailable              bool
 )
 
 func setIntegratorEnv() {
@@ -49,8 +50,11 @@ var _ = Describe("The IaaS Client", func() {
 	BeforeSuite(func() {
 		integratorAccessKeyId = os.Getenv("TEST_AWS_ACCESS_KEY_ID")
 		integratorSecretAccessKey = os.Getenv("TEST_AWS_SECRET_ACCESS_KEY")
-		Ω(integratorAccessKeyId).ShouldNot(BeEmpty(), "You must set TEST_AWS_ACCESS_KEY_ID environment variable")
-		Ω(integratorSecretAccessKey).ShouldNot(BeEmpty(), "You must set TEST_AWS_SECRET_ACCESS_KEY environment variable")
+		// without AWS credentials only the AWS specs are skipped, so that the rest of the suite runs offline
+		awsAvailable = integratorAccessKeyId != "" && integratorSecretAccessKey != ""
+		if !awsAvailable {
+			return
+		}
 		if os.Getenv("INTEGRATOR") != "" {
 			integratorName = os.Getenv("INTEGRATOR")
 		} else {
@@ -77,6 +81,9 @@ var _ = Describe("The IaaS Client", func() {
 	})
 
 	AfterSuite(func() {
+		if !awsAvailable {
+			return
+		}
 		setIntegratorEnv()
 		client = AwsClient{Region: region, ClientId: clientName}
 		_, err = client.DeleteClientUser(true)
@@ -88,11 +95,16 @@ var _ = Describe("The IaaS Client", func() {
 
 		var (
 			result         []string
-			localFilePath  string
 			remoteFilePath string
 			status         bool
 		)
 
+		BeforeEach(func() {
+			if !awsAvailable {
+				Skip("set TEST_AWS_ACCESS_KEY_ID & TEST_AWS_SECRET_ACCESS_KEY to run the AWS specs")
+			}
+		})
+
 		Describe("the integrator-level operations", func() {
 
 			BeforeEach(func() {
@@ -221,7 +233,7 @@ var _ = Describe("The IaaS Client", func() {
 					})
 
 					It("connects correctly & uploads the file", func() {
-						remoteFilePath, err = client.UploadFile("fixtures/test-file.csv", "someother-file.csv")
+						remoteFilePath, _, err = client.UploadFile("fixtures/test-file.csv", "someother-file.csv")
 						Ω(err).ShouldNot(HaveOccurred())
 						Ω(remoteFilePath).Should(Equal("someother-file.csv"))
 
@@ -287,7 +299,7 @@ var _ = Describe("The IaaS Client", func() {
 
 					Context("when uploading a file", func() {
 						BeforeEach(func() {
-							remoteFilePath, err = client.UploadFile("doesntmatter", "doesntmatter")
+							remoteFilePath, _, err = client.UploadFile("doesntmatter", "doesntmatter")
 						})
 						It("throws an error", func() {
 							Ω(err).Should(HaveOccurred())
@@ -297,7 +309,7 @@ var _ = Describe("The IaaS Client", func() {
 					Context("when downloading a file", func() {
 						BeforeEach(func() {
 							tempDir, err = ioutil.TempDir("", "iaas-uploading-files")
-							localFilePath, err = client.GetFile("doesntmatter", tempDir)
+							_, err = client.GetFile("doesntmatter", tempDir)
 						})
 						It("throws an error", func() {
 							Ω(err).Should(HaveOccurred())
@@ -0,0 +1,96 @@
+package iaas
+
+import (
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// LifecycleRule expires the files under a Prefix of the client's upload area, Days after they were last modified
+type LifecycleRule struct {
+	Prefix string
+	Days   int
+}
+
+const (
+	noLifecycleConfigurationCode = "NoSuchLifecycleConfiguration"
+	notImplementedCode           = "NotImplemented"
+)
+
+// lifecycleRuleIdPrefix marks the bucket's lifecycle rules that belong to the client, as the bucket is shared by
+// all of the integrator's clients
+func (client AwsClient) lifecycleRuleIdPrefix() string {
+	return "sched-load:" + client.ClientId + ":"
+}
+
+// PutLifecycleRules replaces the client's rules in the bucket's lifecycle configuration, leaving any other rules
+// as they are. Stores without lifecycle support, such as some S3-compatible stores, are reported as unsupported.
+func (client AwsClient) PutLifecycleRules(rules []LifecycleRule) (supported bool, err error) {
+
+	if err = client.populate(); err != nil {
+		return
+	}
+
+	session, err := client.connect()
+	if err != nil {
+		return
+	}
+	svc := s3.New(session, client.s3Config())
+	bucket := aws.String(client.bucketName())
+
+	var bucketRules []*s3.LifecycleRule
+	resp, err := svc.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{Bucket: bucket})
+	if awsErr, ok := err.(awserr.Error); ok {
+		switch awsErr.Code() {
+		case notImplementedCode:
+			return false, nil
+		case noLifecycleConfigurationCode:
+			err = nil
+		}
+	}
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	if resp != nil {
+		for _, rule := range resp.Rules {
+			if !strings.HasPrefix(aws.StringValue(rule.ID), client.lifecycleRuleIdPrefix()) {
+				bucketRules = append(bucketRules, rule)
+			}
+		}
+	}
+
+	for _, rule := range rules {
+		bucketRules = append(bucketRules, &s3.LifecycleRule{
+			ID:         aws.String(client.lifecycleRuleIdPrefix() + rule.Prefix),
+			Filter:     &s3.LifecycleRuleFilter{Prefix: aws.String(client.ClientId + "/" + rule.Prefix)},
+			Expiration: &s3.LifecycleExpiration{Days: aws.Int64(int64(rule.Days))},
+			Status:     aws.String(s3.ExpirationStatusEnabled),
+		})
+	}
+
+	// S3 refuses a configuration without rules, so the configuration is removed once the last rule goes
+	if len(bucketRules) == 0 {
+		_, err = svc.DeleteBucketLifecycle(&s3.DeleteBucketLifecycleInput{Bucket: bucket})
+	} else {
+		_, err = svc.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+			Bucket:                 bucket,
+			LifecycleConfiguration: &s3.BucketLifecycleConfiguration{Rules: bucketRules},
+		})
+	}
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	log.Println("Lifecycle rules set for", client.ClientId)
+	return true, nil
+}
+
+// PutLifecycleRules is unsupported, as nothing expires files on a filesystem
+func (client FilesystemClient) PutLifecycleRules(rules []LifecycleRule) (supported bool, err error) {
+	err = client.populate()
+	return
+}
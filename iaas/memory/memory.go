@@ -0,0 +1,527 @@
+// Package memory provides a stateful, in-memory implementation of iaas.IaaSClient,
+// with hooks for injecting errors & latency, for use when testing code built on sched-load.
+package memory
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhrapson/sched-load/iaas"
+)
+
+// Store holds the objects, upload notifications & client users for any number of integrators.
+// Every Client sharing a Store sees the same state, as every AwsClient sharing an account would.
+type Store struct {
+	mutex       sync.Mutex
+	integrators map[string]*integratorState
+	faults      map[string]*fault
+	calls       map[string]int
+	latency     time.Duration
+}
+
+type integratorState struct {
+	objects       map[string]object
+	notifications map[string]bool
+	clientUsers   map[string]iaas.IaaSCredentials
+}
+
+type object struct {
+	contents     []byte
+	lastModified time.Time
+	metadata     map[string]string
+}
+
+// eTag is the MD5 of the contents, as S3 gives for a single-part upload
+func (obj object) eTag() string {
+	sum := md5.Sum(obj.contents)
+	return hex.EncodeToString(sum[:])
+}
+
+type fault struct {
+	err       error
+	remaining int
+}
+
+type Credentials struct {
+	ClientId  string
+	SecretKey string
+}
+
+func (creds Credentials) String() (output string) {
+	return "ClientId: " + creds.ClientId + ", SecretKey: " + creds.SecretKey
+}
+
+func (creds Credentials) Map() map[string]string {
+	m := make(map[string]string)
+	m["ClientId"] = creds.ClientId
+	m["SecretKey"] = creds.SecretKey
+	return m
+}
+
+func NewStore() *Store {
+	return &Store{
+		integrators: map[string]*integratorState{},
+		faults:      map[string]*fault{},
+		calls:       map[string]int{},
+	}
+}
+
+// FailOn makes every subsequent call to the named IaaSClient operation (e.g. "UploadFile") return err
+func (store *Store) FailOn(operation string, err error) {
+	store.FailTimes(operation, -1, err)
+}
+
+// FailTimes makes the next n calls to the named IaaSClient operation return err
+func (store *Store) FailTimes(operation string, n int, err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.faults[operation] = &fault{err: err, remaining: n}
+}
+
+func (store *Store) ClearFaults() {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.faults = map[string]*fault{}
+}
+
+// SetLatency delays every subsequent operation by the given duration
+func (store *Store) SetLatency(latency time.Duration) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.latency = latency
+}
+
+// Calls gives the number of times the named IaaSClient operation has been invoked, including failed calls
+func (store *Store) Calls(operation string) int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.calls[operation]
+}
+
+// Put stores an object directly, bypassing fault injection, for setting up test scenarios.
+// The key is relative to the integrator, i.e. <client>/INPUT/<file>
+func (store *Store) Put(integratorId string, key string, contents []byte, lastModified time.Time) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.integrator(integratorId).objects[key] = object{contents: contents, lastModified: lastModified}
+}
+
+// Get retrieves an object directly, bypassing fault injection
+func (store *Store) Get(integratorId string, key string) (contents []byte, found bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	obj, found := store.integrator(integratorId).objects[key]
+	return obj.contents, found
+}
+
+// enter records the call, applies any latency & returns any injected fault. The caller must unlock the store.
+func (store *Store) enter(operation string) error {
+	store.mutex.Lock()
+	store.calls[operation]++
+	latency := store.latency
+	if latency > 0 {
+		store.mutex.Unlock()
+		time.Sleep(latency)
+		store.mutex.Lock()
+	}
+
+	injected, ok := store.faults[operation]
+	if !ok {
+		return nil
+	}
+	if injected.remaining == 0 {
+		delete(store.faults, operation)
+		return nil
+	}
+	if injected.remaining > 0 {
+		injected.remaining--
+	}
+	return injected.err
+}
+
+func (store *Store) integrator(integratorId string) *integratorState {
+	state, ok := store.integrators[integratorId]
+	if !ok {
+		state = &integratorState{
+			objects:       map[string]object{},
+			notifications: map[string]bool{},
+			clientUsers:   map[string]iaas.IaaSCredentials{},
+		}
+		store.integrators[integratorId] = state
+	}
+	return state
+}
+
+// Client implements iaas.IaaSClient against a Store
+type Client struct {
+	Store        *Store
+	IntegratorId string
+	ClientId     string
+	// Compression, gzip or zstd, compresses files as they are uploaded, & Encryption encrypts them client-side
+	Compression string
+	Encryption  iaas.KeyWrapper
+	// OnConflict is what GetFile does when the local file already exists: overwrite, skip or rename
+	OnConflict string
+}
+
+func (client Client) RemoveFileUploadNotification() (wasPreExisting bool, err error) {
+	state, err := client.begin("RemoveFileUploadNotification", true)
+	defer client.Store.mutex.Unlock()
+	if err != nil {
+		return
+	}
+
+	wasPreExisting = state.notifications[client.ClientId]
+	delete(state.notifications, client.ClientId)
+	return
+}
+
+func (client Client) FileUploadNotification() (isSet bool, err error) {
+	state, err := client.begin("FileUploadNotification", true)
+	defer client.Store.mutex.Unlock()
+	if err != nil {
+		return
+	}
+
+	isSet = state.notifications[client.ClientId]
+	return
+}
+
+func (client Client) AddFileUploadNotification() (wasNewConfiguration bool, err error) {
+	state, err := client.begin("AddFileUploadNotification", true)
+	defer client.Store.mutex.Unlock()
+	if err != nil {
+		return
+	}
+
+	wasNewConfiguration = !state.notifications[client.ClientId]
+	state.notifications[client.ClientId] = true
+	return
+}
+
+func (client Client) ListFiles() (names []string, err error) {
+	names = []string{}
+	state, err := client.begin("ListFiles", true)
+	defer client.Store.mutex.Unlock()
+	if err != nil {
+		return
+	}
+
+	prefix := client.ClientId + "/"
+	for key := range state.objects {
+		if strings.HasPrefix(key, prefix) {
+			names = append(names, strings.TrimPrefix(key, prefix))
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+func (client Client) ListFileInfos() (files []iaas.FileInfo, err error) {
+	files = []iaas.FileInfo{}
+	state, err := client.begin("ListFileInfos", true)
+	defer client.Store.mutex.Unlock()
+	if err != nil {
+		return
+	}
+	files = append(files, client.fileInfos(state, client.ClientId+"/")...)
+	return
+}
+
+// fileInfos describes the client's objects whose keys start with the prefix, in order of name
+func (client Client) fileInfos(state *integratorState, prefix string) (files []iaas.FileInfo) {
+	clientPrefix := client.ClientId + "/"
+	for key, obj := range state.objects {
+		if strings.HasPrefix(key, prefix) {
+			files = append(files, iaas.FileInfo{
+				Name:         strings.TrimPrefix(key, clientPrefix),
+				Size:         int64(len(obj.contents)),
+				LastModified: obj.lastModified,
+				ETag:         obj.eTag(),
+				StorageClass: iaas.StandardStorageClass,
+			})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return
+}
+
+// WalkFiles gives the client's files whose names start with the prefix, in order. The store is only locked while
+// the files are listed, so that walkFn may call the client.
+func (client Client) WalkFiles(prefix string, walkFn iaas.WalkFunc) (err error) {
+	state, err := client.begin("WalkFiles", true)
+	if err != nil {
+		client.Store.mutex.Unlock()
+		return
+	}
+	files := client.fileInfos(state, client.ClientId+"/"+prefix)
+	client.Store.mutex.Unlock()
+
+	for _, file := range files {
+		if err = walkFn(file); err != nil {
+			break
+		}
+	}
+	if err == iaas.StopWalk {
+		return nil
+	}
+	return
+}
+
+func (client Client) StatFile(remotePath string) (info iaas.FileInfo, err error) {
+	state, err := client.begin("StatFile", true)
+	defer client.Store.mutex.Unlock()
+	if err != nil {
+		return
+	}
+
+	obj, found := state.objects[client.key(remotePath)]
+	if !found {
+		err = errors.New("NotFound: The specified key does not exist: " + remotePath)
+		return
+	}
+	info = iaas.FileInfoFromMetadata(remotePath, int64(len(obj.contents)), obj.lastModified, func(key string) string {
+		return obj.metadata[key]
+	})
+	info.ETag = obj.eTag()
+	info.StorageClass = iaas.StandardStorageClass
+	return
+}
+
+func (client Client) ListClients() (clientIds []string, err error) {
+	clientIds = []string{}
+	state, err := client.begin("ListClients", false)
+	defer client.Store.mutex.Unlock()
+	if err != nil {
+		return
+	}
+
+	found := map[string]bool{}
+	for key := range state.objects {
+		clientId := strings.SplitN(key, "/", 2)[0]
+		if !found[clientId] {
+			found[clientId] = true
+			clientIds = append(clientIds, clientId)
+		}
+	}
+	sort.Strings(clientIds)
+	return
+}
+
+func (client Client) ForClient(clientId string) iaas.IaaSClient {
+	client.ClientId = clientId
+	return client
+}
+
+func (client Client) WithoutEncoding() iaas.IaaSClient {
+	client.Compression, client.Encryption = "", nil
+	return client
+}
+
+// AbortIncompleteUploads has nothing to abort, as every upload to the store completes at once
+func (client Client) AbortIncompleteUploads(initiatedBefore time.Time) (names []string, err error) {
+	names = []string{}
+	_, err = client.begin("AbortIncompleteUploads", true)
+	defer client.Store.mutex.Unlock()
+	return
+}
+
+// EncryptionStatus is always none, as the store is never written anywhere
+func (client Client) EncryptionStatus() (description string, err error) {
+	_, err = client.begin("EncryptionStatus", false)
+	defer client.Store.mutex.Unlock()
+	if err != nil {
+		return
+	}
+	return iaas.SSENone, nil
+}
+
+// PutLifecycleRules is unsupported, as the store never expires objects
+func (client Client) PutLifecycleRules(rules []iaas.LifecycleRule) (supported bool, err error) {
+	_, err = client.begin("PutLifecycleRules", true)
+	defer client.Store.mutex.Unlock()
+	return
+}
+
+func (client Client) DeleteFile(remotePath string) (wasPreExisting bool, err error) {
+	state, err := client.begin("DeleteFile", true)
+	defer client.Store.mutex.Unlock()
+	if err != nil {
+		return
+	}
+
+	key := client.key(remotePath)
+	_, wasPreExisting = state.objects[key]
+	delete(state.objects, key)
+	return
+}
+
+func (client Client) DeleteFiles(remotePaths []string) (deleted []string, err error) {
+	deleted = []string{}
+	state, err := client.begin("DeleteFiles", true)
+	defer client.Store.mutex.Unlock()
+	if err != nil {
+		return
+	}
+
+	for _, remotePath := range remotePaths {
+		key := client.key(remotePath)
+		if _, found := state.objects[key]; found {
+			delete(state.objects, key)
+			deleted = append(deleted, remotePath)
+		}
+	}
+	return
+}
+
+func (client Client) MoveFile(sourcePath string, targetPath string) (err error) {
+	state, err := client.begin("MoveFile", true)
+	defer client.Store.mutex.Unlock()
+	if err != nil {
+		return
+	}
+
+	obj, found := state.objects[client.key(sourcePath)]
+	if !found {
+		return errors.New("NoSuchKey: The specified key does not exist: " + sourcePath)
+	}
+	obj.metadata = iaas.MovedMetadata(obj.metadata, obj.lastModified)
+	obj.lastModified = time.Now().UTC()
+	state.objects[client.key(targetPath)] = obj
+	delete(state.objects, client.key(sourcePath))
+	return
+}
+
+func (client Client) UploadFile(filepath string, targetName string) (name string, checksum string, err error) {
+	state, err := client.begin("UploadFile", true)
+	defer client.Store.mutex.Unlock()
+	if err != nil {
+		return
+	}
+
+	stream, err := iaas.OpenUpload(filepath, client.Compression, client.Encryption)
+	if err != nil {
+		return
+	}
+	defer stream.Close()
+	contents, err := ioutil.ReadAll(stream)
+	if err != nil {
+		return
+	}
+	state.objects[client.key(targetName)] = object{
+		contents:     contents,
+		lastModified: time.Now().UTC(),
+		metadata:     stream.Metadata,
+	}
+	name, checksum = targetName, stream.Checksum
+	return
+}
+
+func (client Client) GetFile(remotePath string, localDir string) (downloadedFilePath string, err error) {
+	state, err := client.begin("GetFile", true)
+	defer client.Store.mutex.Unlock()
+	if err != nil {
+		return
+	}
+
+	obj, found := state.objects[client.key(remotePath)]
+	if !found {
+		err = errors.New("NoSuchKey: The specified key does not exist: " + remotePath)
+		return
+	}
+
+	return iaas.DownloadTo(localDir, remotePath, client.OnConflict, func(file *os.File) (writeErr error) {
+		_, writeErr = file.Write(obj.contents)
+		return
+	}, func(key string) string {
+		return obj.metadata[key]
+	}, client.Encryption)
+}
+
+func (client Client) CreateClientUser() (credentials iaas.IaaSCredentials, err error) {
+	state, err := client.begin("CreateClientUser", true)
+	defer client.Store.mutex.Unlock()
+	if err != nil {
+		return
+	}
+
+	if _, exists := state.clientUsers[client.ClientId]; exists {
+		err = errors.New("EntityAlreadyExists: User with name " + client.ClientId + " already exists.")
+		return
+	}
+	credentials = Credentials{
+		ClientId:  client.ClientId,
+		SecretKey: fmt.Sprintf("%s-secret-%d", client.ClientId, len(state.clientUsers)+1),
+	}
+	state.clientUsers[client.ClientId] = credentials
+	return
+}
+
+func (client Client) DeleteClientUser(force bool) (wasPreExisting bool, err error) {
+	state, err := client.begin("DeleteClientUser", true)
+	defer client.Store.mutex.Unlock()
+	if err != nil {
+		return
+	}
+
+	_, wasPreExisting = state.clientUsers[client.ClientId]
+	delete(state.clientUsers, client.ClientId)
+
+	if force {
+		prefix := client.ClientId + "/"
+		for key := range state.objects {
+			if strings.HasPrefix(key, prefix) {
+				delete(state.objects, key)
+			}
+		}
+	}
+	return
+}
+
+func (client Client) AccountDetails() (details iaas.IaaSAccountDetails, err error) {
+	_, err = client.begin("AccountDetails", false)
+	defer client.Store.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	details = map[string]string{}
+	details["AccountId"] = "memory"
+	details["IntegratorId"] = client.IntegratorId
+	details["CredentialType"] = "integrator"
+	if client.ClientId != "" {
+		details["ClientId"] = client.ClientId
+	}
+	return
+}
+
+// begin locks the store, which the caller must unlock, and validates the client for the operation
+func (client Client) begin(operation string, needsClient bool) (state *integratorState, err error) {
+	if err = client.Store.enter(operation); err != nil {
+		log.Println(operation, "failed:", err)
+		return
+	}
+	if client.IntegratorId == "" {
+		err = errors.New("You must specify an integrator for the memory backend")
+		return
+	}
+	if needsClient && client.ClientId == "" {
+		err = errors.New("You must specify a client for this operation")
+		return
+	}
+	state = client.Store.integrator(client.IntegratorId)
+	return
+}
+
+func (client Client) key(remotePath string) string {
+	return client.ClientId + "/" + remotePath
+}
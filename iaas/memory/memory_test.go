@@ -0,0 +1,191 @@
+package memory_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/dhrapson/sched-load/iaas"
+	. "github.com/dhrapson/sched-load/iaas/memory"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("The in-memory IaaS Client", func() {
+
+	var (
+		store  *Store
+		client iaas.IaaSClient
+		status bool
+		names  []string
+		err    error
+	)
+
+	BeforeEach(func() {
+		store = NewStore()
+		client = Client{Store: store, IntegratorId: "myintegrator", ClientId: "myclient"}
+	})
+
+	Context("when managing files", func() {
+		It("lists a file after it is uploaded and not after it is deleted", func() {
+			_, _, err = client.UploadFile("../fixtures/test-file.csv", "INPUT/test-file.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			names, err = client.ListFiles()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(names).Should(Equal([]string{"INPUT/test-file.csv"}))
+
+			status, err = client.DeleteFile("INPUT/test-file.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(status).Should(BeTrue())
+
+			names, err = client.ListFiles()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(names).Should(BeEmpty())
+		})
+
+		It("keeps each client's files separate", func() {
+			store.Put("myintegrator", "otherclient/INPUT/other.csv", []byte("other"), time.Now())
+			store.Put("otherintegrator", "myclient/INPUT/elsewhere.csv", []byte("elsewhere"), time.Now())
+			store.Put("myintegrator", "myclient/INPUT/mine.csv", []byte("mine"), time.Now())
+
+			names, err = client.ListFiles()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(names).Should(Equal([]string{"INPUT/mine.csv"}))
+		})
+
+		It("downloads the stored contents", func() {
+			store.Put("myintegrator", "myclient/INPUT/mine.csv", []byte("mine"), time.Now())
+			tempDir, err := ioutil.TempDir("", "memory-get-file")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(tempDir)
+
+			localFilePath, err := client.GetFile("INPUT/mine.csv", tempDir)
+			Ω(err).ShouldNot(HaveOccurred())
+			contents, err := ioutil.ReadFile(localFilePath)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(contents)).Should(Equal("mine"))
+
+			_, err = client.GetFile("INPUT/missing.csv", tempDir)
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("when managing upload notifications", func() {
+		It("tracks the notification per client", func() {
+			status, err = client.AddFileUploadNotification()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(status).Should(BeTrue())
+
+			other := Client{Store: store, IntegratorId: "myintegrator", ClientId: "otherclient"}
+			status, err = other.FileUploadNotification()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(status).Should(BeFalse())
+
+			status, err = client.RemoveFileUploadNotification()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(status).Should(BeTrue())
+		})
+	})
+
+	Context("when managing client users", func() {
+		It("creates the user once and removes the files on forced deletion", func() {
+			_, err = client.CreateClientUser()
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = client.CreateClientUser()
+			Ω(err).Should(HaveOccurred())
+
+			store.Put("myintegrator", "myclient/INPUT/mine.csv", []byte("mine"), time.Now())
+			status, err = client.DeleteClientUser(true)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(status).Should(BeTrue())
+
+			_, found := store.Get("myintegrator", "myclient/INPUT/mine.csv")
+			Ω(found).Should(BeFalse())
+		})
+	})
+
+	Context("when listing across clients", func() {
+		It("gives each client with files, and their sizes & timestamps", func() {
+			lastModified := time.Date(2026, 10, 14, 3, 0, 0, 0, time.UTC)
+			store.Put("myintegrator", "myclient/INPUT/mine.csv", []byte("a,b"), lastModified)
+			store.Put("myintegrator", "otherclient/INPUT/theirs.csv", []byte("c,d"), lastModified)
+
+			clientIds, err := Client{Store: store, IntegratorId: "myintegrator"}.ListClients()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(clientIds).Should(Equal([]string{"myclient", "otherclient"}))
+
+			files, err := client.ForClient("otherclient").ListFileInfos()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(files).Should(Equal([]iaas.FileInfo{{Name: "INPUT/theirs.csv", Size: 3, LastModified: lastModified, ETag: "d8dae83f3216d7c0b0adae9d3e0ae519", StorageClass: "STANDARD"}}))
+		})
+	})
+
+	Context("when walking files", func() {
+		It("gives the files under the prefix in order, until stopped", func() {
+			for _, key := range []string{"myclient/INPUT/b.csv", "myclient/INPUT/a.csv", "myclient/PROCESSED/c.csv", "myclient2/INPUT/d.csv"} {
+				store.Put("myintegrator", key, []byte("a,b"), time.Now())
+			}
+
+			var names []string
+			err := client.WalkFiles("INPUT/", func(file iaas.FileInfo) error {
+				names = append(names, file.Name)
+				return nil
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(names).Should(Equal([]string{"INPUT/a.csv", "INPUT/b.csv"}))
+
+			names = nil
+			err = client.WalkFiles("", func(file iaas.FileInfo) error {
+				names = append(names, file.Name)
+				return iaas.StopWalk
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(names).Should(Equal([]string{"INPUT/a.csv"}))
+		})
+	})
+
+	Context("when operating without a client", func() {
+		It("throws an error for client operations", func() {
+			client = Client{Store: store, IntegratorId: "myintegrator"}
+			_, err = client.ListFiles()
+			Ω(err).Should(MatchError("You must specify a client for this operation"))
+
+			details, err := client.AccountDetails()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(details.HasClientId()).Should(BeFalse())
+		})
+	})
+
+	Context("when injecting faults", func() {
+		It("fails every call until the faults are cleared", func() {
+			store.FailOn("ListFiles", errors.New("InternalError"))
+			_, err = client.ListFiles()
+			Ω(err).Should(MatchError("InternalError"))
+			_, err = client.ListFiles()
+			Ω(err).Should(MatchError("InternalError"))
+
+			store.ClearFaults()
+			_, err = client.ListFiles()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(store.Calls("ListFiles")).Should(Equal(3))
+		})
+
+		It("fails only the requested number of calls", func() {
+			store.FailTimes("UploadFile", 1, errors.New("RequestError"))
+			_, _, err = client.UploadFile("../fixtures/test-file.csv", "INPUT/test-file.csv")
+			Ω(err).Should(MatchError("RequestError"))
+			_, _, err = client.UploadFile("../fixtures/test-file.csv", "INPUT/test-file.csv")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("delays operations", func() {
+			store.SetLatency(20 * time.Millisecond)
+			start := time.Now()
+			_, err = client.ListFiles()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(time.Since(start)).Should(BeNumerically(">=", 20*time.Millisecond))
+		})
+	})
+})
@@ -0,0 +1,341 @@
+package iaas
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// multipartAPI is the part of the S3 API used for resumable uploads
+type multipartAPI interface {
+	CreateMultipartUpload(input *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(input *s3.UploadPartInput) (*s3.UploadPartOutput, error)
+	ListParts(input *s3.ListPartsInput) (*s3.ListPartsOutput, error)
+	CompleteMultipartUpload(input *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(input *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error)
+}
+
+// resumableUpload is a multipart upload whose progress is saved to a local state file after every part,
+// so that when the process is killed, uploading the same file again carries on from the last completed part
+type resumableUpload struct {
+	svc                  multipartAPI
+	stateDir             string
+	bucket               string
+	key                  string
+	localPath            string
+	partSize             int64
+	concurrency          int
+	serverSideEncryption *string
+	kmsKeyId             *string
+	metadata             map[string]*string
+}
+
+// uploadState is the content of the state file, which is only reused for the same, unchanged local file
+type uploadState struct {
+	Bucket    string          `json:"bucket"`
+	Key       string          `json:"key"`
+	LocalPath string          `json:"local_path"`
+	Size      int64           `json:"size"`
+	ModTime   time.Time       `json:"mod_time"`
+	PartSize  int64           `json:"part_size"`
+	UploadId  string          `json:"upload_id"`
+	Parts     []completedPart `json:"parts"`
+}
+
+type completedPart struct {
+	Number int64  `json:"number"`
+	ETag   string `json:"etag"`
+	SHA256 string `json:"sha256"`
+}
+
+func (upload resumableUpload) run() (err error) {
+	if upload.localPath, err = filepath.Abs(upload.localPath); err != nil {
+		return
+	}
+	info, err := os.Stat(upload.localPath)
+	if err != nil {
+		return
+	}
+	file, err := os.Open(upload.localPath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	state, err := upload.resume(file, info)
+	if err != nil {
+		return
+	}
+
+	completed := map[int64]completedPart{}
+	for _, part := range state.Parts {
+		completed[part.Number] = part
+	}
+	var pending []int64
+	partCount := (info.Size() + state.PartSize - 1) / state.PartSize
+	for number := int64(1); number <= partCount; number++ {
+		if _, done := completed[number]; !done {
+			pending = append(pending, number)
+		}
+	}
+	if len(completed) > 0 {
+		log.Printf("Resuming upload of %s with %d of %d parts already uploaded\n", upload.localPath, len(completed), partCount)
+	}
+
+	if err = upload.uploadParts(file, info.Size(), &state, pending); err != nil {
+		return
+	}
+
+	sort.Slice(state.Parts, func(i, j int) bool { return state.Parts[i].Number < state.Parts[j].Number })
+	parts := []*s3.CompletedPart{}
+	for _, part := range state.Parts {
+		parts = append(parts, &s3.CompletedPart{ETag: aws.String(part.ETag), PartNumber: aws.Int64(part.Number)})
+	}
+	_, err = upload.svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(upload.bucket),
+		Key:             aws.String(upload.key),
+		UploadId:        aws.String(state.UploadId),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return
+	}
+	return os.Remove(upload.statePath())
+}
+
+// resume gives the saved state of an earlier attempt at this upload, keeping only the parts that are still
+// intact both locally & in S3, or starts a new multipart upload
+func (upload resumableUpload) resume(file *os.File, info os.FileInfo) (state uploadState, err error) {
+	saved, err := upload.loadState()
+	if err != nil {
+		return
+	}
+
+	if saved.UploadId != "" {
+		if saved.Size != info.Size() || !saved.ModTime.Equal(info.ModTime()) || saved.PartSize != upload.partSize {
+			log.Println("Local file has changed since the last attempt to upload it, starting again")
+			upload.svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(upload.bucket),
+				Key:      aws.String(upload.key),
+				UploadId: aws.String(saved.UploadId),
+			})
+		} else {
+			var uploaded map[int64]string
+			uploaded, err = upload.listParts(saved.UploadId)
+			if isNoSuchUpload(err) {
+				log.Println("The last attempt to upload the file has expired, starting again")
+				err = nil
+			} else if err != nil {
+				return
+			} else {
+				state = saved
+				state.Parts = nil
+				for _, part := range saved.Parts {
+					if uploaded[part.Number] != part.ETag {
+						continue
+					}
+					checksum, checksumErr := partChecksum(file, info.Size(), state.PartSize, part.Number)
+					if checksumErr != nil {
+						return state, checksumErr
+					}
+					if checksum == part.SHA256 {
+						state.Parts = append(state.Parts, part)
+					}
+				}
+				return
+			}
+		}
+	}
+
+	output, err := upload.svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket:               aws.String(upload.bucket),
+		Key:                  aws.String(upload.key),
+		Metadata:             upload.metadata,
+		ServerSideEncryption: upload.serverSideEncryption,
+		SSEKMSKeyId:          upload.kmsKeyId,
+	})
+	if err != nil {
+		return
+	}
+	state = uploadState{
+		Bucket:    upload.bucket,
+		Key:       upload.key,
+		LocalPath: upload.localPath,
+		Size:      info.Size(),
+		ModTime:   info.ModTime(),
+		PartSize:  upload.partSize,
+		UploadId:  *output.UploadId,
+	}
+	err = upload.saveState(state)
+	return
+}
+
+// uploadParts sends the pending parts in parallel, saving the state after each one
+func (upload resumableUpload) uploadParts(file *os.File, size int64, state *uploadState, pending []int64) error {
+	var (
+		mutex    sync.Mutex
+		wait     sync.WaitGroup
+		firstErr error
+	)
+	numbers := make(chan int64)
+
+	concurrency := upload.concurrency
+	if concurrency < 1 {
+		concurrency = s3manager.DefaultUploadConcurrency
+	}
+	for worker := 0; worker < concurrency; worker++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for number := range numbers {
+				part, err := upload.uploadPart(file, size, state.PartSize, state.UploadId, number)
+
+				mutex.Lock()
+				if err == nil {
+					state.Parts = append(state.Parts, part)
+					err = upload.saveState(*state)
+				}
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, number := range pending {
+		mutex.Lock()
+		failed := firstErr != nil
+		mutex.Unlock()
+		if failed {
+			break
+		}
+		numbers <- number
+	}
+	close(numbers)
+	wait.Wait()
+	return firstErr
+}
+
+func (upload resumableUpload) uploadPart(file *os.File, size int64, partSize int64, uploadId string, number int64) (part completedPart, err error) {
+	offset, length := partBounds(size, partSize, number)
+	contents := make([]byte, length)
+	if _, err = file.ReadAt(contents, offset); err != nil && err != io.EOF {
+		return
+	}
+
+	sha := sha256.Sum256(contents)
+	md := md5.Sum(contents)
+	output, err := upload.svc.UploadPart(&s3.UploadPartInput{
+		Bucket:     aws.String(upload.bucket),
+		Key:        aws.String(upload.key),
+		UploadId:   aws.String(uploadId),
+		PartNumber: aws.Int64(number),
+		Body:       bytes.NewReader(contents),
+		ContentMD5: aws.String(base64.StdEncoding.EncodeToString(md[:])),
+	})
+	if err != nil {
+		return
+	}
+	part = completedPart{Number: number, ETag: aws.StringValue(output.ETag), SHA256: hex.EncodeToString(sha[:])}
+	return
+}
+
+// listParts gives the ETag of each part S3 holds for the upload
+func (upload resumableUpload) listParts(uploadId string) (etags map[int64]string, err error) {
+	etags = map[int64]string{}
+	params := &s3.ListPartsInput{
+		Bucket:   aws.String(upload.bucket),
+		Key:      aws.String(upload.key),
+		UploadId: aws.String(uploadId),
+	}
+	for {
+		var output *s3.ListPartsOutput
+		if output, err = upload.svc.ListParts(params); err != nil {
+			return
+		}
+		for _, part := range output.Parts {
+			etags[aws.Int64Value(part.PartNumber)] = aws.StringValue(part.ETag)
+		}
+		if !aws.BoolValue(output.IsTruncated) {
+			return
+		}
+		params.PartNumberMarker = output.NextPartNumberMarker
+	}
+}
+
+// statePath is unique to the local file & its destination
+func (upload resumableUpload) statePath() string {
+	id := sha256.Sum256([]byte(upload.localPath + "\n" + upload.bucket + "/" + upload.key))
+	return filepath.Join(upload.stateDir, hex.EncodeToString(id[:16])+".json")
+}
+
+func (upload resumableUpload) loadState() (state uploadState, err error) {
+	contents, err := ioutil.ReadFile(upload.statePath())
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(contents, &state); err != nil {
+		log.Println("Ignoring unreadable upload state file", upload.statePath())
+		return uploadState{}, nil
+	}
+	return
+}
+
+func (upload resumableUpload) saveState(state uploadState) (err error) {
+	if err = os.MkdirAll(upload.stateDir, 0700); err != nil {
+		return
+	}
+	contents, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return
+	}
+	return copyAtomically(bytes.NewReader(contents), upload.statePath())
+}
+
+func partBounds(size int64, partSize int64, number int64) (offset int64, length int64) {
+	offset = (number - 1) * partSize
+	length = partSize
+	if offset+length > size {
+		length = size - offset
+	}
+	return
+}
+
+// partChecksum gives the SHA-256 of a part of the local file, to check that it is unchanged since the part was uploaded
+func partChecksum(file *os.File, size int64, partSize int64, number int64) (checksum string, err error) {
+	offset, length := partBounds(size, partSize, number)
+	if length <= 0 {
+		return "", errors.New("Part is beyond the end of the file")
+	}
+	hash := sha256.New()
+	if _, err = io.Copy(hash, io.NewSectionReader(file, offset, length)); err != nil {
+		return
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func isNoSuchUpload(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == s3.ErrCodeNoSuchUpload
+}
@@ -0,0 +1,210 @@
+package iaas
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeMultipart keeps multipart uploads in memory, failing the upload of a chosen part
+type fakeMultipart struct {
+	mutex     sync.Mutex
+	uploads   map[string]map[int64]string
+	created   int
+	sent      []int64
+	completed []*s3.CompletedPart
+	aborted   []string
+	failPart  int64
+}
+
+type noSuchUploadError struct{}
+
+func (noSuchUploadError) Error() string   { return "NoSuchUpload: The specified upload does not exist" }
+func (noSuchUploadError) Code() string    { return s3.ErrCodeNoSuchUpload }
+func (noSuchUploadError) Message() string { return "The specified upload does not exist" }
+func (noSuchUploadError) OrigErr() error  { return nil }
+
+func (fake *fakeMultipart) CreateMultipartUpload(input *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.created++
+	uploadId := fmt.Sprintf("upload-%d", fake.created)
+	fake.uploads[uploadId] = map[int64]string{}
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String(uploadId)}, nil
+}
+
+func (fake *fakeMultipart) UploadPart(input *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	number := *input.PartNumber
+	if number == fake.failPart {
+		fake.failPart = 0
+		return nil, errors.New("RequestError: connection reset")
+	}
+	contents, _ := ioutil.ReadAll(input.Body)
+	etag := fmt.Sprintf("%q", contents)
+	fake.uploads[*input.UploadId][number] = etag
+	fake.sent = append(fake.sent, number)
+	return &s3.UploadPartOutput{ETag: aws.String(etag)}, nil
+}
+
+func (fake *fakeMultipart) ListParts(input *s3.ListPartsInput) (*s3.ListPartsOutput, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	parts, found := fake.uploads[*input.UploadId]
+	if !found {
+		return nil, noSuchUploadError{}
+	}
+	output := &s3.ListPartsOutput{}
+	for number, etag := range parts {
+		output.Parts = append(output.Parts, &s3.Part{PartNumber: aws.Int64(number), ETag: aws.String(etag)})
+	}
+	return output, nil
+}
+
+func (fake *fakeMultipart) CompleteMultipartUpload(input *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.completed = input.MultipartUpload.Parts
+	delete(fake.uploads, *input.UploadId)
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (fake *fakeMultipart) UploadPartCopy(input *s3.UploadPartCopyInput) (*s3.UploadPartCopyOutput, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	number := *input.PartNumber
+	if number == fake.failPart {
+		fake.failPart = 0
+		return nil, errors.New("RequestError: connection reset")
+	}
+	etag := fmt.Sprintf("%q", *input.CopySourceRange)
+	fake.uploads[*input.UploadId][number] = etag
+	fake.sent = append(fake.sent, number)
+	return &s3.UploadPartCopyOutput{CopyPartResult: &s3.CopyPartResult{ETag: aws.String(etag)}}, nil
+}
+
+func (fake *fakeMultipart) AbortMultipartUpload(input *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.aborted = append(fake.aborted, *input.UploadId)
+	delete(fake.uploads, *input.UploadId)
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+var _ = Describe("Resumable uploads", func() {
+
+	var (
+		tempDir  string
+		fake     *fakeMultipart
+		upload   resumableUpload
+		err      error
+		numbered = func(parts []*s3.CompletedPart) (numbers []int64) {
+			for _, part := range parts {
+				numbers = append(numbers, *part.PartNumber)
+			}
+			return
+		}
+	)
+
+	BeforeEach(func() {
+		tempDir, err = ioutil.TempDir("", "iaas-resumable")
+		Ω(err).ShouldNot(HaveOccurred())
+		localPath := filepath.Join(tempDir, "extract.csv")
+		Ω(ioutil.WriteFile(localPath, []byte("aaaabbbbccccdd"), 0644)).Should(Succeed())
+
+		fake = &fakeMultipart{uploads: map[string]map[int64]string{}}
+		upload = resumableUpload{
+			svc:         fake,
+			stateDir:    filepath.Join(tempDir, "state"),
+			bucket:      "mybucket",
+			key:         "myclient/INPUT/extract.csv",
+			localPath:   localPath,
+			partSize:    4,
+			concurrency: 1,
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tempDir)
+	})
+
+	It("uploads every part & removes the state file", func() {
+		Ω(upload.run()).Should(Succeed())
+		Ω(numbered(fake.completed)).Should(Equal([]int64{1, 2, 3, 4}))
+		Ω(filepath.Join(tempDir, "state")).Should(BeADirectory())
+		files, _ := ioutil.ReadDir(filepath.Join(tempDir, "state"))
+		Ω(files).Should(BeEmpty())
+	})
+
+	It("resumes from the last completed part", func() {
+		fake.failPart = 3
+		Ω(upload.run()).ShouldNot(Succeed())
+		Ω(fake.sent).Should(Equal([]int64{1, 2}))
+
+		fake.sent = nil
+		Ω(upload.run()).Should(Succeed())
+		Ω(fake.created).Should(Equal(1))
+		Ω(fake.sent).Should(Equal([]int64{3, 4}))
+		Ω(numbered(fake.completed)).Should(Equal([]int64{1, 2, 3, 4}))
+	})
+
+	It("starts again when the local file has changed", func() {
+		fake.failPart = 3
+		Ω(upload.run()).ShouldNot(Succeed())
+		Ω(ioutil.WriteFile(upload.localPath, []byte("eeeeffffgggghhhhii"), 0644)).Should(Succeed())
+
+		fake.sent = nil
+		Ω(upload.run()).Should(Succeed())
+		Ω(fake.aborted).Should(Equal([]string{"upload-1"}))
+		Ω(fake.sent).Should(Equal([]int64{1, 2, 3, 4, 5}))
+	})
+
+	It("starts again when the earlier upload no longer exists", func() {
+		fake.failPart = 3
+		Ω(upload.run()).ShouldNot(Succeed())
+		delete(fake.uploads, "upload-1")
+
+		fake.sent = nil
+		Ω(upload.run()).Should(Succeed())
+		Ω(fake.created).Should(Equal(2))
+		Ω(fake.sent).Should(Equal([]int64{1, 2, 3, 4}))
+	})
+})
+
+var _ = Describe("Multipart copies", func() {
+
+	var (
+		fake   *fakeMultipart
+		upload *s3.CreateMultipartUploadInput
+	)
+
+	BeforeEach(func() {
+		fake = &fakeMultipart{uploads: map[string]map[int64]string{}}
+		upload = &s3.CreateMultipartUploadInput{Bucket: aws.String("mybucket"), Key: aws.String("myclient/PROCESSED/extract.csv")}
+	})
+
+	It("copies the source a range at a time", func() {
+		Ω(multipartCopy(fake, upload, "/mybucket/myclient/INPUT/extract.csv", 10, 4)).Should(Succeed())
+		Ω(fake.completed).Should(HaveLen(3))
+		for i, byteRange := range []string{"bytes=0-3", "bytes=4-7", "bytes=8-9"} {
+			Ω(*fake.completed[i].PartNumber).Should(Equal(int64(i + 1)))
+			Ω(*fake.completed[i].ETag).Should(Equal(fmt.Sprintf("%q", byteRange)))
+		}
+	})
+
+	It("aborts the copy when a part fails", func() {
+		fake.failPart = 2
+		Ω(multipartCopy(fake, upload, "/mybucket/myclient/INPUT/extract.csv", 10, 4)).Should(MatchError("RequestError: connection reset"))
+		Ω(fake.aborted).Should(Equal([]string{"upload-1"}))
+		Ω(fake.completed).Should(BeEmpty())
+	})
+})
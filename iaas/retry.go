@@ -0,0 +1,325 @@
+package iaas
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// retryableCodes are the AWS error codes of failures that are expected to pass, e.g. network errors & throttling
+var retryableCodes = []string{
+	"RequestError",
+	"RequestTimeout",
+	"RequestTimeoutException",
+	"Throttling",
+	"ThrottlingException",
+	"SlowDown",
+	"InternalError",
+	"ServiceUnavailable",
+}
+
+// RetryPolicy describes how often, & how long after, a failed operation is tried again.
+// The delay doubles after each attempt up to MaxDelay, with up to the Jitter fraction of it randomised
+// so that many source systems failing at once do not all retry at once.
+type RetryPolicy struct {
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Jitter       float64
+	// Retryable classifies errors, IsRetryable if nil
+	Retryable func(err error) bool
+	// Sleep waits between attempts, time.Sleep if nil
+	Sleep func(delay time.Duration)
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:  3,
+		InitialDelay: time.Second,
+		MaxDelay:     30 * time.Second,
+		Jitter:       0.5,
+	}
+}
+
+func (policy RetryPolicy) Validate() error {
+	if policy.MaxAttempts < 1 {
+		return errors.New("Retry attempts must be at least 1")
+	}
+	if policy.InitialDelay < 0 || policy.MaxDelay < 0 {
+		return errors.New("Retry delays must not be negative")
+	}
+	if policy.Jitter < 0 || policy.Jitter > 1 {
+		return errors.New("Retry jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// Delay gives the time to wait after the given failed attempt, counting from 1
+func (policy RetryPolicy) Delay(attempt int) time.Duration {
+	delay := policy.InitialDelay
+	for i := 1; i < attempt && delay < policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	jitter := time.Duration(float64(delay) * policy.Jitter * rand.Float64())
+	return delay - jitter
+}
+
+// IsRetryable tells whether an error is likely to be transient: a network error, throttling, or a server error
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if requestFailure, ok := err.(awserr.RequestFailure); ok {
+		if requestFailure.StatusCode() >= 500 || requestFailure.StatusCode() == 429 {
+			return true
+		}
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	message := err.Error()
+	if awsErr, ok := err.(awserr.Error); ok {
+		message = awsErr.Code()
+	}
+	for _, code := range retryableCodes {
+		if strings.HasPrefix(message, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryingClient wraps an IaaSClient, trying each operation again when it fails with a retryable error
+type RetryingClient struct {
+	Client IaaSClient
+	Policy RetryPolicy
+}
+
+func (client RetryingClient) retry(operation string, call func() error) (err error) {
+	retryable := client.Policy.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	sleep := client.Policy.Sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err = call(); err == nil {
+			if attempt > 1 {
+				log.Printf("%s succeeded on attempt %d of %d\n", operation, attempt, client.Policy.MaxAttempts)
+			}
+			return
+		}
+		if !retryable(err) {
+			return
+		}
+		if attempt >= client.Policy.MaxAttempts {
+			log.Printf("%s failed on attempt %d of %d, giving up: %s\n", operation, attempt, client.Policy.MaxAttempts, err)
+			return
+		}
+		delay := client.Policy.Delay(attempt)
+		log.Printf("%s failed on attempt %d of %d, retrying in %s: %s\n", operation, attempt, client.Policy.MaxAttempts, delay, err)
+		sleep(delay)
+	}
+}
+
+func (client RetryingClient) DeleteFile(remotePath string) (wasPreExisting bool, err error) {
+	err = client.retry("DeleteFile", func() (callErr error) {
+		wasPreExisting, callErr = client.Client.DeleteFile(remotePath)
+		return
+	})
+	return
+}
+
+// DeleteFiles gives the files deleted over every attempt, each retry only deleting the files still remaining
+func (client RetryingClient) DeleteFiles(remotePaths []string) (deleted []string, err error) {
+	deleted = []string{}
+	remaining := remotePaths
+	err = client.retry("DeleteFiles", func() error {
+		attemptDeleted, callErr := client.Client.DeleteFiles(remaining)
+		deleted = append(deleted, attemptDeleted...)
+
+		wasDeleted := map[string]bool{}
+		for _, remotePath := range attemptDeleted {
+			wasDeleted[remotePath] = true
+		}
+		var stillRemaining []string
+		for _, remotePath := range remaining {
+			if !wasDeleted[remotePath] {
+				stillRemaining = append(stillRemaining, remotePath)
+			}
+		}
+		remaining = stillRemaining
+		return callErr
+	})
+	return
+}
+
+func (client RetryingClient) MoveFile(sourcePath string, targetPath string) (err error) {
+	return client.retry("MoveFile", func() error {
+		return client.Client.MoveFile(sourcePath, targetPath)
+	})
+}
+
+func (client RetryingClient) GetFile(remotePath string, localDir string) (downloadedFilePath string, err error) {
+	err = client.retry("GetFile", func() (callErr error) {
+		downloadedFilePath, callErr = client.Client.GetFile(remotePath, localDir)
+		return
+	})
+	return
+}
+
+func (client RetryingClient) ListFiles() (names []string, err error) {
+	err = client.retry("ListFiles", func() (callErr error) {
+		names, callErr = client.Client.ListFiles()
+		return
+	})
+	return
+}
+
+func (client RetryingClient) ListFileInfos() (files []FileInfo, err error) {
+	err = client.retry("ListFileInfos", func() (callErr error) {
+		files, callErr = client.Client.ListFileInfos()
+		return
+	})
+	return
+}
+
+// WalkFiles retries the listing from the start, skipping the files up to the last one given, as a listing is not
+// resumable across attempts. Files are listed in order of name, so files added or removed in between are neither
+// repeated nor missed. Errors from walkFn end the walk without retrying.
+func (client RetryingClient) WalkFiles(prefix string, walkFn WalkFunc) (err error) {
+	var last string
+	given := false
+	var walkErr error
+	err = client.retry("WalkFiles", func() error {
+		return client.Client.WalkFiles(prefix, func(file FileInfo) error {
+			if given && file.Name <= last {
+				return nil
+			}
+			last, given = file.Name, true
+			if walkErr = walkFn(file); walkErr != nil {
+				return StopWalk
+			}
+			return nil
+		})
+	})
+	if walkErr != nil && walkErr != StopWalk {
+		return walkErr
+	}
+	return
+}
+
+func (client RetryingClient) StatFile(remotePath string) (info FileInfo, err error) {
+	err = client.retry("StatFile", func() (callErr error) {
+		info, callErr = client.Client.StatFile(remotePath)
+		return
+	})
+	return
+}
+
+func (client RetryingClient) UploadFile(filepath string, target string) (name string, checksum string, err error) {
+	err = client.retry("UploadFile", func() (callErr error) {
+		name, checksum, callErr = client.Client.UploadFile(filepath, target)
+		return
+	})
+	return
+}
+
+func (client RetryingClient) AddFileUploadNotification() (wasNewConfiguration bool, err error) {
+	err = client.retry("AddFileUploadNotification", func() (callErr error) {
+		wasNewConfiguration, callErr = client.Client.AddFileUploadNotification()
+		return
+	})
+	return
+}
+
+func (client RetryingClient) FileUploadNotification() (isSet bool, err error) {
+	err = client.retry("FileUploadNotification", func() (callErr error) {
+		isSet, callErr = client.Client.FileUploadNotification()
+		return
+	})
+	return
+}
+
+func (client RetryingClient) RemoveFileUploadNotification() (wasPreExisting bool, err error) {
+	err = client.retry("RemoveFileUploadNotification", func() (callErr error) {
+		wasPreExisting, callErr = client.Client.RemoveFileUploadNotification()
+		return
+	})
+	return
+}
+
+// CreateClientUser is not retried, as creating the user & its access key is not idempotent, so a retry after
+// a request that failed late could create a second access key or fail as the user already exists
+func (client RetryingClient) CreateClientUser() (credentials IaaSCredentials, err error) {
+	return client.Client.CreateClientUser()
+}
+
+func (client RetryingClient) DeleteClientUser(force bool) (wasPreExisting bool, err error) {
+	err = client.retry("DeleteClientUser", func() (callErr error) {
+		wasPreExisting, callErr = client.Client.DeleteClientUser(force)
+		return
+	})
+	return
+}
+
+func (client RetryingClient) AccountDetails() (details IaaSAccountDetails, err error) {
+	err = client.retry("AccountDetails", func() (callErr error) {
+		details, callErr = client.Client.AccountDetails()
+		return
+	})
+	return
+}
+
+func (client RetryingClient) ListClients() (clientIds []string, err error) {
+	err = client.retry("ListClients", func() (callErr error) {
+		clientIds, callErr = client.Client.ListClients()
+		return
+	})
+	return
+}
+
+func (client RetryingClient) ForClient(clientId string) IaaSClient {
+	client.Client = client.Client.ForClient(clientId)
+	return client
+}
+
+func (client RetryingClient) WithoutEncoding() IaaSClient {
+	client.Client = client.Client.WithoutEncoding()
+	return client
+}
+
+func (client RetryingClient) AbortIncompleteUploads(initiatedBefore time.Time) (names []string, err error) {
+	err = client.retry("AbortIncompleteUploads", func() (callErr error) {
+		names, callErr = client.Client.AbortIncompleteUploads(initiatedBefore)
+		return
+	})
+	return
+}
+
+func (client RetryingClient) EncryptionStatus() (description string, err error) {
+	err = client.retry("EncryptionStatus", func() (callErr error) {
+		description, callErr = client.Client.EncryptionStatus()
+		return
+	})
+	return
+}
+
+func (client RetryingClient) PutLifecycleRules(rules []LifecycleRule) (supported bool, err error) {
+	err = client.retry("PutLifecycleRules", func() (callErr error) {
+		supported, callErr = client.Client.PutLifecycleRules(rules)
+		return
+	})
+	return
+}
@@ -0,0 +1,158 @@
+package iaas_test
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	. "github.com/dhrapson/sched-load/iaas"
+	"github.com/dhrapson/sched-load/iaas/memory"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// interruptedWalker fails a walk once part way through, as a listing interrupted between pages would
+type interruptedWalker struct {
+	IaaSClient
+	failAfter   int
+	interrupted *bool
+	onInterrupt func()
+}
+
+func (client interruptedWalker) WalkFiles(prefix string, walkFn WalkFunc) error {
+	given := 0
+	return client.IaaSClient.WalkFiles(prefix, func(file FileInfo) error {
+		if given == client.failAfter && !*client.interrupted {
+			*client.interrupted = true
+			if client.onInterrupt != nil {
+				client.onInterrupt()
+			}
+			return errors.New("RequestError: send request failed")
+		}
+		given++
+		return walkFn(file)
+	})
+}
+
+var _ = Describe("Retrying IaaS operations", func() {
+
+	var (
+		store  *memory.Store
+		client RetryingClient
+		delays []time.Duration
+	)
+
+	BeforeEach(func() {
+		store = memory.NewStore()
+		delays = nil
+		client = RetryingClient{
+			Client: memory.Client{Store: store, IntegratorId: "myintegrator", ClientId: "myclient"},
+			Policy: RetryPolicy{
+				MaxAttempts:  4,
+				InitialDelay: time.Second,
+				MaxDelay:     3 * time.Second,
+				Sleep:        func(delay time.Duration) { delays = append(delays, delay) },
+			},
+		}
+	})
+
+	It("retries a transient failure with exponential backoff", func() {
+		store.FailTimes("ListFiles", 3, errors.New("RequestError: send request failed"))
+		_, err := client.ListFiles()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(store.Calls("ListFiles")).Should(Equal(4))
+		Ω(delays).Should(Equal([]time.Duration{time.Second, 2 * time.Second, 3 * time.Second}))
+	})
+
+	It("gives up after the maximum number of attempts", func() {
+		store.FailOn("UploadFile", errors.New("SlowDown: Please reduce your request rate"))
+		_, _, err := client.UploadFile("fixtures/test-file.csv", "INPUT/test-file.csv")
+		Ω(err).Should(MatchError("SlowDown: Please reduce your request rate"))
+		Ω(store.Calls("UploadFile")).Should(Equal(4))
+	})
+
+	It("does not retry a permanent failure", func() {
+		store.FailOn("DeleteFile", errors.New("AccessDenied: Access Denied"))
+		_, err := client.DeleteFile("INPUT/test-file.csv")
+		Ω(err).Should(HaveOccurred())
+		Ω(store.Calls("DeleteFile")).Should(Equal(1))
+		Ω(delays).Should(BeEmpty())
+	})
+
+	It("keeps retrying once scoped to another client", func() {
+		store.FailTimes("ListFileInfos", 1, errors.New("InternalError"))
+		_, err := client.ForClient("otherclient").ListFileInfos()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(store.Calls("ListFileInfos")).Should(Equal(2))
+	})
+
+	It("randomises no more than the jitter fraction of each delay", func() {
+		policy := RetryPolicy{InitialDelay: time.Second, MaxDelay: time.Minute, Jitter: 0.5}
+		for i := 0; i < 20; i++ {
+			Ω(policy.Delay(3)).Should(BeNumerically(">", 2*time.Second))
+			Ω(policy.Delay(3)).Should(BeNumerically("<=", 4*time.Second))
+		}
+	})
+
+	It("classifies network errors as retryable", func() {
+		Ω(IsRetryable(&net.OpError{Op: "dial", Err: errors.New("connection refused")})).Should(BeTrue())
+		Ω(IsRetryable(errors.New("NoSuchKey: The specified key does not exist"))).Should(BeFalse())
+	})
+
+	It("rejects an invalid policy", func() {
+		Ω(RetryPolicy{MaxAttempts: 0}.Validate()).Should(MatchError("Retry attempts must be at least 1"))
+		Ω(RetryPolicy{MaxAttempts: 1, Jitter: 2}.Validate()).Should(MatchError("Retry jitter must be between 0 and 1"))
+	})
+
+	It("resumes an interrupted walk without repeating files", func() {
+		for _, name := range []string{"a.csv", "b.csv", "c.csv"} {
+			store.Put("myintegrator", "myclient/INPUT/"+name, []byte(name), time.Now())
+		}
+		interrupted := false
+		client.Client = interruptedWalker{IaaSClient: client.Client, failAfter: 2, interrupted: &interrupted}
+
+		var names []string
+		err := client.WalkFiles("INPUT/", func(file FileInfo) error {
+			names = append(names, file.Name)
+			return nil
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(interrupted).Should(BeTrue())
+		Ω(names).Should(Equal([]string{"INPUT/a.csv", "INPUT/b.csv", "INPUT/c.csv"}))
+	})
+
+	It("resumes after the last file given, when files were removed in between", func() {
+		for _, name := range []string{"a.csv", "b.csv", "c.csv"} {
+			store.Put("myintegrator", "myclient/INPUT/"+name, []byte(name), time.Now())
+		}
+		interrupted := false
+		client.Client = interruptedWalker{IaaSClient: client.Client, failAfter: 2, interrupted: &interrupted, onInterrupt: func() {
+			memory.Client{Store: store, IntegratorId: "myintegrator", ClientId: "myclient"}.DeleteFile("INPUT/a.csv")
+		}}
+
+		var names []string
+		err := client.WalkFiles("INPUT/", func(file FileInfo) error {
+			names = append(names, file.Name)
+			return nil
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(names).Should(Equal([]string{"INPUT/a.csv", "INPUT/b.csv", "INPUT/c.csv"}))
+	})
+
+	It("does not retry creating the client user", func() {
+		store.FailTimes("CreateClientUser", 1, errors.New("RequestError: send request failed"))
+		_, err := client.CreateClientUser()
+		Ω(err).Should(MatchError("RequestError: send request failed"))
+		Ω(store.Calls("CreateClientUser")).Should(Equal(1))
+		Ω(delays).Should(BeEmpty())
+	})
+
+	It("does not retry a walk stopped by its walk function", func() {
+		store.Put("myintegrator", "myclient/INPUT/a.csv", []byte("a"), time.Now())
+		err := client.WalkFiles("", func(file FileInfo) error {
+			return errors.New("RequestError: send request failed")
+		})
+		Ω(err).Should(MatchError("RequestError: send request failed"))
+		Ω(store.Calls("WalkFiles")).Should(Equal(1))
+	})
+})
@@ -0,0 +1,157 @@
+package iaas
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Server-side encryption modes of uploads to S3, with none & bucket-default leaving it to the bucket
+const (
+	SSENone          = "none"
+	SSEAES256        = "AES256"
+	SSEKMS           = "aws:kms"
+	SSEBucketDefault = "bucket-default"
+)
+
+const noBucketEncryptionCode = "ServerSideEncryptionConfigurationNotFoundError"
+
+// ValidateServerSideEncryption checks the mode is known, & that a KMS key is only given for aws:kms
+func ValidateServerSideEncryption(mode string, kmsKeyId string) error {
+	switch mode {
+	case "", SSENone, SSEAES256, SSEKMS, SSEBucketDefault:
+	default:
+		return errors.New("Unknown server-side encryption " + mode + ", expected none, AES256, aws:kms or bucket-default")
+	}
+	if kmsKeyId != "" && mode != SSEKMS {
+		return errors.New("A KMS key can only be given for aws:kms server-side encryption")
+	}
+	return nil
+}
+
+// DescribeServerSideEncryption gives the encryption of an object or bucket as reported by S3
+func DescribeServerSideEncryption(algorithm string, kmsKeyId string) string {
+	if algorithm == "" {
+		return SSENone
+	}
+	if algorithm == SSEKMS {
+		if kmsKeyId == "" {
+			return "aws:kms with the AWS managed key"
+		}
+		return "aws:kms with key " + kmsKeyId
+	}
+	return algorithm
+}
+
+// Encryption describes how a stored file is encrypted, both server-side & client-side
+func (info FileInfo) Encryption() string {
+	description := DescribeServerSideEncryption(info.ServerSideEncryption, info.SSEKMSKeyId)
+	if info.EncryptionKeyId != "" {
+		description += ", client-side with key " + info.EncryptionKeyId
+	}
+	return description
+}
+
+// serverSideEncryption gives the mode of encryption uploads ask for, AES256 unless another is set
+func (client AwsClient) serverSideEncryption() (sse *string, kmsKeyId *string) {
+	switch client.ServerSideEncryption {
+	case "":
+		return aws.String(SSEAES256), nil
+	case SSENone, SSEBucketDefault:
+		return nil, nil
+	case SSEKMS:
+		if client.KMSKeyId != "" {
+			kmsKeyId = aws.String(client.KMSKeyId)
+		}
+		return aws.String(SSEKMS), kmsKeyId
+	}
+	return aws.String(client.ServerSideEncryption), nil
+}
+
+// EncryptionStatus describes the encryption of new uploads, checking that a customer-managed KMS key can be used
+func (client AwsClient) EncryptionStatus() (description string, err error) {
+
+	if err = ValidateServerSideEncryption(client.ServerSideEncryption, client.KMSKeyId); err != nil {
+		return
+	}
+
+	switch client.ServerSideEncryption {
+	case "", SSEAES256:
+		return SSEAES256, nil
+	case SSEKMS:
+		if err = client.checkKMSKey(); err != nil {
+			return
+		}
+		return DescribeServerSideEncryption(SSEKMS, client.KMSKeyId), nil
+	}
+
+	rules, err := client.bucketEncryption()
+	if err != nil {
+		return
+	}
+	if len(rules) > 0 {
+		return "bucket default, " + strings.Join(rules, ", "), nil
+	}
+	// the bucket default mode relies on the bucket, so check it is set up
+	if client.ServerSideEncryption == SSEBucketDefault {
+		return "", errors.New("Bucket " + client.bucketName() + " has no default encryption, so the bucket-default mode cannot be used")
+	}
+	return SSENone, nil
+}
+
+// bucketEncryption describes each rule of the bucket's default encryption, giving none without default encryption
+func (client AwsClient) bucketEncryption() (rules []string, err error) {
+	if err = client.populateIntegrator(); err != nil {
+		return
+	}
+	session, err := client.connect()
+	if err != nil {
+		return
+	}
+	svc := s3.New(session, client.s3Config())
+
+	resp, err := svc.GetBucketEncryption(&s3.GetBucketEncryptionInput{Bucket: aws.String(client.bucketName())})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == noBucketEncryptionCode {
+		return nil, nil
+	}
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+
+	if resp.ServerSideEncryptionConfiguration != nil {
+		for _, rule := range resp.ServerSideEncryptionConfiguration.Rules {
+			if byDefault := rule.ApplyServerSideEncryptionByDefault; byDefault != nil {
+				rules = append(rules, DescribeServerSideEncryption(aws.StringValue(byDefault.SSEAlgorithm), aws.StringValue(byDefault.KMSMasterKeyID)))
+			}
+		}
+	}
+	return
+}
+
+// checkKMSKey checks that the customer-managed KMS key exists & is enabled, as uploads would fail otherwise.
+// The AWS managed key is always usable, & S3-compatible stores have no AWS KMS to check with.
+func (client AwsClient) checkKMSKey() (err error) {
+	if client.KMSKeyId == "" || client.Endpoint != "" {
+		return
+	}
+	session, err := client.connect()
+	if err != nil {
+		return
+	}
+	svc := kms.New(session)
+
+	resp, err := svc.DescribeKey(&kms.DescribeKeyInput{KeyId: aws.String(client.KMSKeyId)})
+	if err != nil {
+		return errors.New("KMS key " + client.KMSKeyId + " cannot be used: " + err.Error())
+	}
+	if resp.KeyMetadata == nil || aws.StringValue(resp.KeyMetadata.KeyState) != kms.KeyStateEnabled {
+		return errors.New("KMS key " + client.KMSKeyId + " is not enabled")
+	}
+	return
+}
@@ -0,0 +1,60 @@
+package iaas_test
+
+import (
+	. "github.com/dhrapson/sched-load/iaas"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AWS multipart upload options", func() {
+
+	var awsClient AwsClient
+
+	BeforeEach(func() {
+		awsClient = AwsClient{Endpoint: "https://localhost:9000", IntegratorId: "myintegrator", ClientId: "myclient"}
+	})
+
+	It("rejects a part size below the S3 minimum", func() {
+		awsClient.PartSize = 1024 * 1024
+		_, _, err := awsClient.UploadFile("fixtures/test-file.csv", "INPUT/test-file.csv")
+		Ω(err).Should(MatchError("Part size must be at least 5MB"))
+	})
+
+	It("rejects a negative concurrency", func() {
+		awsClient.Concurrency = -1
+		_, _, err := awsClient.UploadFile("fixtures/test-file.csv", "INPUT/test-file.csv")
+		Ω(err).Should(MatchError("Concurrency must not be negative"))
+	})
+
+	It("rejects an unknown compression", func() {
+		awsClient.Compression = "lz4"
+		_, _, err := awsClient.UploadFile("fixtures/test-file.csv", "INPUT/test-file.csv")
+		Ω(err).Should(MatchError("Unknown compression lz4, expected gzip or zstd"))
+	})
+
+	It("rejects an unknown server-side encryption", func() {
+		awsClient.ServerSideEncryption = "aws:kms:dsse"
+		_, _, err := awsClient.UploadFile("fixtures/test-file.csv", "INPUT/test-file.csv")
+		Ω(err).Should(MatchError("Unknown server-side encryption aws:kms:dsse, expected none, AES256, aws:kms or bucket-default"))
+	})
+
+	It("rejects a KMS key without aws:kms server-side encryption", func() {
+		awsClient.KMSKeyId = "alias/myclient"
+		_, err := awsClient.EncryptionStatus()
+		Ω(err).Should(MatchError("A KMS key can only be given for aws:kms server-side encryption"))
+	})
+
+	It("reports the server-side encryption of uploads", func() {
+		Ω(awsClient.EncryptionStatus()).Should(Equal("AES256"))
+		awsClient.ServerSideEncryption = SSEKMS
+		Ω(awsClient.EncryptionStatus()).Should(Equal("aws:kms with the AWS managed key"))
+		awsClient.KMSKeyId = "alias/myclient"
+		Ω(awsClient.EncryptionStatus()).Should(Equal("aws:kms with key alias/myclient"))
+	})
+
+	It("describes the encryption of stored files", func() {
+		info := FileInfo{ServerSideEncryption: SSEKMS, SSEKMSKeyId: "arn:aws:kms:eu-west-1:123:key/abc", EncryptionKeyId: "local-kms:myclient"}
+		Ω(info.Encryption()).Should(Equal("aws:kms with key arn:aws:kms:eu-west-1:123:key/abc, client-side with key local-kms:myclient"))
+		Ω(FileInfo{}.Encryption()).Should(Equal("none"))
+	})
+})
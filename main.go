@@ -1,20 +1,73 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/dhrapson/sched-load/config"
 	"github.com/dhrapson/sched-load/controller"
 	"github.com/dhrapson/sched-load/iaas"
 	"github.com/urfave/cli"
 )
 
 var (
-	region   string
-	clientId string
-	filePath string
-	force    bool
+	region       string
+	clientId     string
+	integratorId string
+	backend      string
+	rootDir      string
+	endpoint     string
+	pathStyle    bool
+	insecure     bool
+	caBundle     string
+	filePath     string
+	force        bool
+	day          string
+	timezone     string
+	window       string
+	filePattern  string
+	gracePeriod  time.Duration
+	ownerContact string
+	count        int
+	until        string
+	partSize     int64
+	concurrency  int
+	stateDir     string
+	olderThan    time.Duration
+	checksums    bool
+	spool        bool
+	recursive    bool
+	compression  string
+	publicKey    string
+	privateKey   string
+	kmsDir       string
+	kmsKey       string
+	sse          string
+	sseKMSKey    string
+	encryption   bool
+	long         bool
+	prefix       string
+	limit        int
+	dryRun       bool
+	localDir     string
+	moveTo       string
+	onConflict   string
+	outboxDir    string
+	outputFormat string
+	profileName  string
+	configPath   string
+	credentials  string
+	credsProfile string
+	proxy        string
+	folderName   string
+	days         int
+	sweep        bool
+	retryPolicy  = iaas.DefaultRetryPolicy()
 )
 
 func main() {
@@ -26,14 +79,177 @@ func main() {
 	flags := []cli.Flag{
 		cli.StringFlag{
 			Name:        "region, r",
+			EnvVar:      "SCHED_LOAD_REGION",
 			Usage:       "public IaaS region for storing the files",
 			Destination: &region,
 		},
 		cli.StringFlag{
 			Name:        "client, c",
+			EnvVar:      "SCHED_LOAD_CLIENT",
 			Usage:       "identifier for the client",
 			Destination: &clientId,
 		},
+		cli.StringFlag{
+			Name:        "integrator, i",
+			EnvVar:      "SCHED_LOAD_INTEGRATOR",
+			Usage:       "identifier for the integrator, required for the filesystem backend & custom endpoints",
+			Destination: &integratorId,
+		},
+		cli.StringFlag{
+			Name:        "backend, b",
+			EnvVar:      "SCHED_LOAD_BACKEND",
+			Value:       "aws",
+			Usage:       "storage backend for the files: aws or filesystem",
+			Destination: &backend,
+		},
+		cli.StringFlag{
+			Name:        "root",
+			EnvVar:      "SCHED_LOAD_ROOT",
+			Usage:       "root directory for the filesystem backend",
+			Destination: &rootDir,
+		},
+		cli.StringFlag{
+			Name:        "endpoint",
+			EnvVar:      "SCHED_LOAD_ENDPOINT",
+			Usage:       "endpoint URL of an S3-compatible store, e.g. MinIO, used with the aws backend",
+			Destination: &endpoint,
+		},
+		cli.StringFlag{
+			Name:        "credentials",
+			EnvVar:      "SCHED_LOAD_CREDENTIALS",
+			Usage:       "where AWS credentials come from: env or shared, trying each in turn if unset",
+			Destination: &credentials,
+		},
+		cli.StringFlag{
+			Name:        "credentials-profile",
+			EnvVar:      "SCHED_LOAD_CREDENTIALS_PROFILE",
+			Usage:       "profile of the shared AWS credentials file to use",
+			Destination: &credsProfile,
+		},
+		cli.StringFlag{
+			Name:        "proxy",
+			EnvVar:      "SCHED_LOAD_PROXY",
+			Usage:       "URL of an HTTP proxy to connect through, rather than any given by HTTP_PROXY & HTTPS_PROXY",
+			Destination: &proxy,
+		},
+		cli.StringFlag{
+			Name:        "profile",
+			EnvVar:      "SCHED_LOAD_PROFILE",
+			Usage:       "named profile of the config file to take settings from, with flags & SCHED_LOAD_* variables taking precedence",
+			Destination: &profileName,
+		},
+		cli.StringFlag{
+			Name:        "config",
+			EnvVar:      "SCHED_LOAD_CONFIG",
+			Usage:       "path to the config file of named profiles",
+			Value:       config.DefaultPath(),
+			Destination: &configPath,
+		},
+		cli.BoolFlag{
+			Name:        "path-style",
+			Usage:       "use path-style addressing of buckets, as most S3-compatible stores require",
+			Destination: &pathStyle,
+		},
+		cli.BoolFlag{
+			Name:        "insecure-skip-verify",
+			Usage:       "do not verify the TLS certificate of the endpoint",
+			Destination: &insecure,
+		},
+		cli.StringFlag{
+			Name:        "ca-bundle",
+			Usage:       "path to a PEM file of additional CA certificates for verifying the endpoint",
+			Destination: &caBundle,
+		},
+		cli.IntFlag{
+			Name:        "retries",
+			Value:       retryPolicy.MaxAttempts,
+			Usage:       "number of attempts at each IaaS operation that fails with a network, throttling or server error",
+			Destination: &retryPolicy.MaxAttempts,
+		},
+		cli.DurationFlag{
+			Name:        "retry-delay",
+			Value:       retryPolicy.InitialDelay,
+			Usage:       "delay before the first retry, doubling for each retry after it",
+			Destination: &retryPolicy.InitialDelay,
+		},
+		cli.DurationFlag{
+			Name:        "retry-max-delay",
+			Value:       retryPolicy.MaxDelay,
+			Usage:       "longest delay between retries",
+			Destination: &retryPolicy.MaxDelay,
+		},
+		cli.Float64Flag{
+			Name:        "retry-jitter",
+			Value:       retryPolicy.Jitter,
+			Usage:       "fraction of each retry delay that is randomised, from 0 to 1",
+			Destination: &retryPolicy.Jitter,
+		},
+		cli.StringFlag{
+			Name:        "public-key",
+			Usage:       "PEM file of the client's RSA public key, to encrypt data files client-side as they are uploaded",
+			Destination: &publicKey,
+		},
+		cli.StringFlag{
+			Name:        "private-key",
+			Usage:       "PEM file of the client's RSA private key, to decrypt data files encrypted client-side as they are downloaded",
+			Destination: &privateKey,
+		},
+		cli.StringFlag{
+			Name:        "kms-dir",
+			Usage:       "directory of local KMS master keys, to encrypt & decrypt data files client-side instead of with RSA keys",
+			Destination: &kmsDir,
+		},
+		cli.StringFlag{
+			Name:        "kms-key",
+			Usage:       "ID of the local KMS master key to encrypt data files with",
+			Destination: &kmsKey,
+		},
+		cli.StringFlag{
+			Name:        "sse",
+			Usage:       "server-side encryption of uploads to S3: none, AES256, aws:kms or bucket-default",
+			Value:       iaas.SSEAES256,
+			Destination: &sse,
+		},
+		cli.StringFlag{
+			Name:        "sse-kms-key",
+			Usage:       "ID or ARN of the customer-managed KMS key for aws:kms server-side encryption",
+			Destination: &sseKMSKey,
+		},
+		cli.StringFlag{
+			Name:        "output",
+			Usage:       "format of each command's result: text logged to stderr, or json or yaml written to stdout",
+			Value:       TextOutput,
+			Destination: &outputFormat,
+		},
+	}
+
+	scheduleFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:        "timezone, tz",
+			Usage:       "IANA timezone of the source system, e.g. Europe/London",
+			Value:       "UTC",
+			Destination: &timezone,
+		},
+		cli.StringFlag{
+			Name:        "window, w",
+			Usage:       "local time window in which files are expected to arrive, e.g. 02:00-04:00",
+			Destination: &window,
+		},
+		cli.StringFlag{
+			Name:        "pattern, p",
+			Usage:       "shell pattern that the expected file names match, e.g. extract-*.csv",
+			Destination: &filePattern,
+		},
+		cli.DurationFlag{
+			Name:        "grace, g",
+			Usage:       "how late a file may be before it is reported missing, e.g. 2h",
+			Destination: &gracePeriod,
+		},
+		cli.StringFlag{
+			Name:        "owner, o",
+			Usage:       "who to contact when an expected file does not arrive",
+			Destination: &ownerContact,
+		},
 	}
 
 	app.Commands = []cli.Command{
@@ -43,22 +259,30 @@ func main() {
 			Usage:   "show status of connection and schedule",
 			Action: func(c *cli.Context) error {
 
-				clientId = strings.ToLower(clientId)
-				iaasClient := iaas.AwsClient{Region: region, ClientId: clientId}
-				ctrler := controller.Controller{Client: iaasClient}
+				ctrler := newController()
 				details, err := ctrler.Status()
 				if err != nil {
 					log.Fatalf("Error: %s\n", err.Error())
 				}
-				log.Println("connected to IaaS")
-				log.Println("Credential Type: " + details["CredentialType"])
-				if details["ClientId"] == "" {
-					log.Println("Client ID: none set")
-				} else {
-					log.Println("Client ID: " + details["ClientId"])
+				result := statusResult{
+					CredentialType: details["CredentialType"],
+					ClientId:       details["ClientId"],
+					IntegratorId:   details["IntegratorId"],
+					AccountId:      details["AccountId"],
+					Encryption:     details["Encryption"],
 				}
-				log.Println("Integrator ID: " + details["IntegratorId"])
-				log.Println("Account ID: " + details["AccountId"])
+				printResult(result, func() {
+					log.Println("connected to IaaS")
+					log.Println("Credential Type: " + details["CredentialType"])
+					if details["ClientId"] == "" {
+						log.Println("Client ID: none set")
+					} else {
+						log.Println("Client ID: " + details["ClientId"])
+					}
+					log.Println("Integrator ID: " + details["IntegratorId"])
+					log.Println("Account ID: " + details["AccountId"])
+					log.Println("Encryption: " + details["Encryption"])
+				})
 
 				return nil
 			},
@@ -81,26 +305,26 @@ func main() {
 					},
 					Action: func(c *cli.Context) error {
 
-						clientId = strings.ToLower(clientId)
-						iaasClient := iaas.AwsClient{Region: region, ClientId: clientId}
-						controller := controller.Controller{Client: iaasClient}
+						controller := newController()
 
 						wasPreExisting, err := controller.DeleteClientUser(force)
 						if err != nil {
 							log.Fatalf("Error: %s\n", err.Error())
 						}
-						if wasPreExisting {
-							if force {
-								log.Printf("deleted account %s, left data files in place\n", clientId)
+						printResult(clientResult{ClientId: clientId, Existed: wasPreExisting}, func() {
+							if wasPreExisting {
+								if force {
+									log.Printf("deleted account %s, left data files in place\n", clientId)
+								} else {
+									log.Printf("deleted account %s & all data files\n", clientId)
+								}
 							} else {
-								log.Printf("deleted account %s & all data files\n", clientId)
+								log.Printf("%s account did not exist\n", clientId)
+								if force {
+									log.Printf("removed any data files for account %s\n", clientId)
+								}
 							}
-						} else {
-							log.Printf("%s account did not exist\n", clientId)
-							if force {
-								log.Printf("removed any data files for account %s\n", clientId)
-							}
-						}
+						})
 						return nil
 					},
 				},
@@ -110,17 +334,17 @@ func main() {
 					Usage:   "create a client account",
 					Action: func(c *cli.Context) error {
 
-						clientId = strings.ToLower(clientId)
-						iaasClient := iaas.AwsClient{Region: region, ClientId: clientId}
-						controller := controller.Controller{Client: iaasClient}
+						controller := newController()
 
 						creds, err := controller.CreateClientUser()
 						if err != nil {
 							log.Fatalf("Error: %s\n", err.Error())
 						}
 
-						log.Printf("created account %s\n", clientId)
-						log.Printf("Credentials are %s\n", creds.String())
+						printResult(clientResult{ClientId: clientId, Created: true, Credentials: creds.Map()}, func() {
+							log.Printf("created account %s\n", clientId)
+							log.Printf("Credentials are %s\n", creds.String())
+						})
 
 						return nil
 					},
@@ -135,29 +359,88 @@ func main() {
 				{
 					Name:    "delete",
 					Aliases: []string{"d"},
-					Usage:   "remove a remote data file",
+					Usage:   "remove a remote data file, or the data files under a prefix or older than an age",
 					Flags: []cli.Flag{
 						cli.StringFlag{
 							Name:        "remote, r",
 							Usage:       "remote file path",
 							Destination: &filePath,
 						},
+						cli.StringFlag{
+							Name:        "prefix, p",
+							Usage:       "remove the files whose paths within INPUT/ start with the prefix, e.g. 2017/",
+							Destination: &prefix,
+						},
+						cli.DurationFlag{
+							Name:        "older-than",
+							Usage:       "remove the files last modified at least this long ago, e.g. 720h",
+							Destination: &olderThan,
+						},
+						cli.BoolFlag{
+							Name:        "dry-run",
+							Usage:       "only list the files that would be removed",
+							Destination: &dryRun,
+						},
 					},
 					Action: func(c *cli.Context) error {
 
-						clientId = strings.ToLower(clientId)
-						iaasClient := iaas.AwsClient{Region: region, ClientId: clientId}
-						controller := controller.Controller{Client: iaasClient}
+						ctrler := newController()
+
+						if filePath == "" {
+							if !c.IsSet("prefix") && !c.IsSet("older-than") {
+								log.Fatalln("Error: You must specify a remote file, or a prefix or age of the files to remove")
+							}
+							options := controller.DeleteOptions{Prefix: prefix, OlderThan: olderThan, DryRun: dryRun}
+							files, err := ctrler.DeleteDataFiles(options, time.Now())
+							printResult(filesResult{Files: newFileResults(files), DryRun: dryRun}, func() {
+								verb := "deleted"
+								if dryRun {
+									verb = "would delete"
+								}
+								for _, file := range files {
+									log.Printf("%s %s\t%d\t%s\n", verb, file.Name, file.Size, file.LastModified.UTC().Format(time.RFC3339))
+								}
+								log.Printf("%s %d files\n", verb, len(files))
+							})
+							if err != nil {
+								log.Fatalf("Error: %s\n", err.Error())
+							}
+							return nil
+						}
+						if dryRun {
+							exists, err := ctrler.DataFileExists(filePath)
+							if err != nil {
+								log.Fatalf("Error: %s\n", err.Error())
+							}
+							var found []string
+							if exists {
+								found = append(found, "INPUT/"+filePath)
+							}
+							printResult(filesResult{Files: newNameResults(found), DryRun: true}, func() {
+								if exists {
+									log.Printf("would delete INPUT/%s\n", filePath)
+								} else {
+									log.Printf("INPUT/%s not found\n", filePath)
+								}
+							})
+							return nil
+						}
 
-						wasPreExisting, err := controller.DeleteDataFile(filePath)
+						wasPreExisting, err := ctrler.DeleteDataFile(filePath)
 						if err != nil {
 							log.Fatalf("Error: %s\n", err.Error())
 						}
+						var deleted []string
 						if wasPreExisting {
-							log.Printf("deleted %s\n", filePath)
-						} else {
-							log.Printf("%s did not exist\n", filePath)
+							deleted = append(deleted, "INPUT/"+filePath)
 						}
+						printResult(filesResult{Files: newNameResults(deleted)}, func() {
+							if wasPreExisting {
+								log.Printf("deleted %s\n", filePath)
+							} else {
+								log.Printf("%s did not exist\n", filePath)
+							}
+						})
 
 						return nil
 					},
@@ -166,26 +449,114 @@ func main() {
 					Name:    "list-uploaded",
 					Aliases: []string{"lu"},
 					Usage:   "list remote unprocessed data files",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:        "prefix, p",
+							Usage:       "only list files whose paths within INPUT/ start with the prefix, e.g. 2017/",
+							Destination: &prefix,
+						},
+						cli.IntFlag{
+							Name:        "limit",
+							Usage:       "list at most this many files",
+							Destination: &limit,
+						},
+						cli.BoolFlag{
+							Name:        "long, l",
+							Usage:       "show the size, last modified time, storage class & ETag of each file",
+							Destination: &long,
+						},
+						cli.BoolFlag{
+							Name:        "checksums",
+							Usage:       "show the SHA-256 checksum stored with each file on upload",
+							Destination: &checksums,
+						},
+						cli.BoolFlag{
+							Name:        "encryption",
+							Usage:       "show how each file is encrypted, server-side & client-side",
+							Destination: &encryption,
+						},
+					},
 					Action: func(c *cli.Context) error {
 
-						clientId = strings.ToLower(clientId)
-						iaasClient := iaas.AwsClient{Region: region, ClientId: clientId}
-						controller := controller.Controller{Client: iaasClient}
+						ctrler := newController()
+
+						var filesList string
+						files := []fileResult{}
+
+						// only checksums & encryption need each file to be looked up
+						options := controller.ListOptions{Prefix: prefix, Limit: limit, WithDetails: checksums || encryption}
+						truncated, err := ctrler.WalkDataFiles(options, func(file iaas.FileInfo) error {
+							result := newFileResult(file)
+							if encryption {
+								result.Encryption = file.Encryption()
+							}
+							files = append(files, result)
 
-						files, err := controller.ListDataFiles()
+							details := ""
+							if long {
+								details += fmt.Sprintf("\t%d\t%s\t%s\t%s", file.Size, file.LastModified.UTC().Format(time.RFC3339), file.StorageClass, file.ETag)
+							}
+							if checksums {
+								checksum := file.Checksum
+								if checksum == "" && file.EncryptionKeyId != "" {
+									checksum = "sealed checksum"
+								} else if checksum == "" {
+									checksum = "no checksum"
+								}
+								details += "\t" + checksum
+								if file.Compression != "" {
+									details += "\t" + file.Compression
+								}
+								if file.EncryptionKeyId != "" && !encryption {
+									details += "\tencrypted with " + file.EncryptionKeyId
+								}
+							}
+							if encryption {
+								details += "\tencryption: " + file.Encryption()
+							}
+							filesList += "\t" + file.Name + details + "\n"
+							return nil
+						})
 						if err != nil {
 							log.Fatalf("Error: %s\n", err.Error())
 						}
 
-						var filesList string
+						printResult(filesResult{Files: files, Truncated: truncated}, func() {
+							if filesList == "" {
+								filesList = "\tnone found"
+							}
+							if truncated {
+								filesList += fmt.Sprintf("\t... more files not shown, over the limit of %d\n", limit)
+							}
+							log.Printf("listing files:\n%s", filesList)
+						})
 
-						if len(files) == 0 {
-							filesList = "\tnone found"
-						}
-						for _, filePath := range files {
-							filesList += "\t" + filePath + "\n"
+						return nil
+					},
+				},
+				{
+					Name:    "list-processed",
+					Aliases: []string{"lp"},
+					Usage:   "list remote data files that have been collected & moved to PROCESSED/ or ARCHIVE/",
+					Action: func(c *cli.Context) error {
+
+						controller := newController()
+
+						files, err := controller.ListProcessedDataFiles()
+						if err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
 						}
-						log.Printf("listing files:\n%s", filesList)
+
+						printResult(filesResult{Files: newNameResults(files)}, func() {
+							var filesList string
+							if len(files) == 0 {
+								filesList = "\tnone found"
+							}
+							for _, filePath := range files {
+								filesList += "\t" + filePath + "\n"
+							}
+							log.Printf("listing files:\n%s", filesList)
+						})
 
 						return nil
 					},
@@ -193,29 +564,327 @@ func main() {
 				{
 					Name:    "upload",
 					Aliases: []string{"u"},
-					Usage:   "upload a data file",
+					Usage:   "upload data files",
 					Flags: []cli.Flag{
 						cli.StringFlag{
 							Name:        "file, f",
-							Usage:       "path to the local file",
+							Usage:       "path to the local file, a directory of files or a glob pattern, e.g. 'exports/*.csv'",
 							Destination: &filePath,
 						},
+						cli.BoolFlag{
+							Name:        "recursive, R",
+							Usage:       "include the files in sub-directories, keeping their paths under INPUT/",
+							Destination: &recursive,
+						},
+						cli.StringSliceFlag{
+							Name:  "include",
+							Usage: "only upload files whose name or relative path matches this glob pattern, may be repeated",
+						},
+						cli.StringSliceFlag{
+							Name:  "exclude",
+							Usage: "skip files whose name or relative path matches this glob pattern, may be repeated",
+						},
+						cli.StringFlag{
+							Name:        "compress",
+							Usage:       "compress files as they are uploaded, with gzip or zstd, to be decompressed when downloaded",
+							Destination: &compression,
+						},
+						cli.Int64Flag{
+							Name:        "part-size",
+							Usage:       "size in MB of each part of a multipart upload, at least 5",
+							Destination: &partSize,
+						},
+						cli.IntFlag{
+							Name:        "concurrency",
+							Usage:       "number of parts of a multipart upload to send at once",
+							Destination: &concurrency,
+						},
+						cli.StringFlag{
+							Name:        "state-dir",
+							Usage:       "directory to save the progress of multipart uploads in, so that an interrupted upload resumes when run again",
+							Value:       defaultStateDir(),
+							Destination: &stateDir,
+						},
+						cli.BoolFlag{
+							Name:        "spool",
+							Usage:       "upload any files left in the outbox first, & keep the file in the outbox if the upload fails",
+							Destination: &spool,
+						},
+						cli.StringFlag{
+							Name:        "outbox",
+							Usage:       "directory to keep data files in while they cannot be uploaded",
+							Value:       defaultOutboxDir(),
+							Destination: &outboxDir,
+						},
 					},
 					Action: func(c *cli.Context) error {
 
-						clientId = strings.ToLower(clientId)
-						iaasClient := iaas.AwsClient{Region: region, ClientId: clientId}
-						controller := controller.Controller{Client: iaasClient}
-
-						if fileName, err := controller.UploadDataFile(filePath); err != nil {
+						selection := controller.FileSelection{
+							Pattern:   filePath,
+							Recursive: recursive,
+							Include:   c.StringSlice("include"),
+							Exclude:   c.StringSlice("exclude"),
+						}
+						files, err := selection.Files()
+						if err != nil {
 							log.Fatalf("Error: %s\n", err.Error())
+						}
+
+						ctrler := newController()
+
+						var results []controller.UploadResult
+						if spool {
+							results = ctrler.UploadDataFilesOrSpool(files, newOutbox())
 						} else {
-							log.Printf("uploaded %s\n", fileName)
+							results = ctrler.UploadDataFiles(files)
+						}
+
+						uploaded, spooled, failed := 0, 0, 0
+						uploads := []fileResult{}
+						for _, result := range results {
+							upload := fileResult{Name: result.FileName, Path: result.File.Path, SpooledTo: result.SpooledPath}
+							if result.Err != nil {
+								upload.Error = result.Err.Error()
+							}
+							uploads = append(uploads, upload)
+
+							if result.SpooledPath != "" {
+								spooled++
+							} else if result.Err != nil {
+								failed++
+							} else {
+								uploaded++
+							}
+						}
+						printResult(filesResult{Files: uploads}, func() {
+							for _, result := range results {
+								if result.SpooledPath != "" {
+									log.Printf("spooled %s to %s for the next run or flush: %s\n", result.File.Path, result.SpooledPath, result.Err.Error())
+								} else if result.Err != nil {
+									log.Printf("failed %s: %s\n", result.File.Path, result.Err.Error())
+								} else {
+									log.Printf("uploaded %s\n", result.FileName)
+								}
+							}
+							if len(results) > 1 {
+								log.Printf("%d files: %d uploaded, %d spooled, %d failed\n", len(results), uploaded, spooled, failed)
+							}
+						})
+
+						// a partial failure exits differently from a total one, & a spooled file from both, so that callers can tell them apart
+						if failed == len(results) {
+							os.Exit(1)
+						} else if failed > 0 {
+							os.Exit(2)
+						} else if spooled > 0 {
+							os.Exit(3)
+						}
+						return nil
+					},
+				},
+				{
+					Name:    "download",
+					Aliases: []string{"dl"},
+					Usage:   "download an uploaded data file, decompressing it if it was compressed on upload",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:        "remote, file, f",
+							Usage:       "name of the uploaded file, as listed by list-uploaded, e.g. INPUT/extract.csv",
+							Destination: &filePath,
+						},
+						cli.StringFlag{
+							Name:        "dir, d",
+							Usage:       "local directory to download the file to, beneath which its remote path is mirrored",
+							Value:       ".",
+							Destination: &localDir,
+						},
+						cli.StringFlag{
+							Name:        "move-to",
+							Usage:       "once downloaded, move the file from INPUT/ to processed or archive",
+							Destination: &moveTo,
+						},
+						cli.StringFlag{
+							Name:        "on-conflict",
+							Usage:       "what to do when a local file already exists: overwrite, skip or rename the new file",
+							Value:       iaas.OverwriteExisting,
+							Destination: &onConflict,
+						},
+					},
+					Action: func(c *cli.Context) error {
+
+						ctrler := newController()
+
+						folder, err := controller.ParseFolder(moveTo)
+						if err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+						result := fileResult{Name: filePath}
+						result.LocalPath, err = ctrler.DownloadDataFile(filePath, localDir)
+						if err == iaas.ErrSkipped {
+							// the file was already downloaded, so it is left in INPUT/
+							result.Skipped = true
+						} else if err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+						if folder != "" && !result.Skipped {
+							if result.MovedTo, err = ctrler.MoveDataFile(filePath, folder); err != nil {
+								log.Printf("downloaded %s\n", result.LocalPath)
+								log.Fatalf("Error: %s\n", err.Error())
+							}
+						}
+
+						printResult(result, func() {
+							if result.Skipped {
+								log.Printf("skipped %s as %s already exists\n", filePath, result.LocalPath)
+								return
+							}
+							log.Printf("downloaded %s\n", result.LocalPath)
+							if result.MovedTo != "" {
+								log.Printf("moved %s to %s\n", filePath, result.MovedTo)
+							}
+						})
+
+						return nil
+					},
+				},
+				{
+					Name:  "collect",
+					Usage: "download every data file in INPUT/, optionally moving each to processed or archive once downloaded",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:        "dir, d",
+							Usage:       "local directory to download the files to, beneath which their remote paths are mirrored",
+							Value:       ".",
+							Destination: &localDir,
+						},
+						cli.StringFlag{
+							Name:        "move-to",
+							Usage:       "once downloaded, move each file from INPUT/ to processed or archive",
+							Destination: &moveTo,
+						},
+						cli.StringFlag{
+							Name:        "on-conflict",
+							Usage:       "what to do when a local file already exists: overwrite, skip or rename the new file",
+							Value:       iaas.OverwriteExisting,
+							Destination: &onConflict,
+						},
+					},
+					Action: func(c *cli.Context) error {
+
+						ctrler := newController()
+
+						folder, err := controller.ParseFolder(moveTo)
+						if err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+						results, err := ctrler.CollectDataFiles(localDir, folder)
+						if err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+						failed, skipped := 0, 0
+						files := []fileResult{}
+						for _, result := range results {
+							file := fileResult{Name: result.FileName, LocalPath: result.LocalPath, MovedTo: result.MovedTo, Skipped: result.Skipped}
+							if result.Err != nil {
+								file.Error = result.Err.Error()
+								failed++
+							} else if result.Skipped {
+								skipped++
+							}
+							files = append(files, file)
+						}
+						printResult(filesResult{Files: files}, func() {
+							if len(results) == 0 {
+								log.Println("no files to collect")
+								return
+							}
+							for _, result := range results {
+								if result.Err != nil {
+									log.Printf("failed %s: %s\n", result.FileName, result.Err.Error())
+								} else if result.Skipped {
+									log.Printf("skipped %s as %s already exists\n", result.FileName, result.LocalPath)
+								} else if result.MovedTo != "" {
+									log.Printf("downloaded %s to %s & moved it to %s\n", result.FileName, result.LocalPath, result.MovedTo)
+								} else {
+									log.Printf("downloaded %s to %s\n", result.FileName, result.LocalPath)
+								}
+							}
+							log.Printf("%d files: %d collected, %d skipped, %d failed\n", len(results), len(results)-skipped-failed, skipped, failed)
+						})
+						if len(results) == 0 {
+							return nil
+						}
+
+						// a partial failure exits differently from a total one, as for uploads
+						if failed == len(results) {
+							os.Exit(1)
+						} else if failed > 0 {
+							os.Exit(2)
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "flush",
+					Usage: "upload the data files left in the outbox after failed uploads, in the order they were spooled",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:        "outbox",
+							Usage:       "directory to keep data files in while they cannot be uploaded",
+							Value:       defaultOutboxDir(),
+							Destination: &outboxDir,
+						},
+					},
+					Action: func(c *cli.Context) error {
+
+						controller := newController()
+
+						fileNames, err := controller.FlushOutbox(newOutbox())
+						printResult(filesResult{Files: newNameResults(fileNames)}, func() {
+							for _, fileName := range fileNames {
+								log.Printf("uploaded %s\n", fileName)
+							}
+							if err == nil && len(fileNames) == 0 {
+								log.Println("The outbox is empty")
+							}
+						})
+						if err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
 						}
 
 						return nil
 					},
 				},
+				{
+					Name:  "abort-incomplete",
+					Usage: "clean up uploads that were interrupted & never completed",
+					Flags: []cli.Flag{
+						cli.DurationFlag{
+							Name:        "older-than",
+							Usage:       "only abort uploads started at least this long ago, so that uploads in progress are left alone",
+							Value:       24 * time.Hour,
+							Destination: &olderThan,
+						},
+					},
+					Action: func(c *cli.Context) error {
+
+						controller := newController()
+
+						names, err := controller.AbortIncompleteUploads(time.Now().Add(-olderThan))
+						if err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+						printResult(filesResult{Files: newNameResults(names)}, func() {
+							if len(names) == 0 {
+								log.Println("No incomplete uploads found")
+							}
+							for _, name := range names {
+								log.Printf("aborted upload of %s\n", name)
+							}
+						})
+						return nil
+					},
+				},
 			},
 		},
 		{
@@ -228,19 +897,19 @@ func main() {
 					Usage: "show the immediate data file collection status",
 					Action: func(c *cli.Context) error {
 
-						clientId = strings.ToLower(clientId)
-						iaasClient := iaas.AwsClient{Region: region, ClientId: clientId}
-						controller := controller.Controller{Client: iaasClient}
+						controller := newController()
 
 						status, err := controller.ImmediateDataFileCollectionStatus()
 						if err != nil {
 							log.Fatalf("Error: %s\n", err.Error())
 						}
-						if status {
-							log.Println("Immediate collection status is enabled")
-						} else {
-							log.Println("Immediate collection status is disabled")
-						}
+						printResult(collectionResult{Enabled: status}, func() {
+							if status {
+								log.Println("Immediate collection status is enabled")
+							} else {
+								log.Println("Immediate collection status is disabled")
+							}
+						})
 						return nil
 					},
 				},
@@ -249,19 +918,20 @@ func main() {
 					Usage: "enable immediate data file collection",
 					Action: func(c *cli.Context) error {
 
-						clientId = strings.ToLower(clientId)
-						iaasClient := iaas.AwsClient{Region: region, ClientId: clientId}
-						controller := controller.Controller{Client: iaasClient}
+						controller := newController()
 
 						wasNewlySet, err := controller.EnableImmediateDataFileCollection()
 						if err != nil {
 							log.Fatalf("Error: %s\n", err.Error())
 						}
-						if wasNewlySet {
-							log.Println("Enabled immediate collection")
-						} else {
-							log.Println("Immediate collection was already enabled")
-						}
+						enabled := true
+						printResult(changeResult{Changed: wasNewlySet, Enabled: &enabled}, func() {
+							if wasNewlySet {
+								log.Println("Enabled immediate collection")
+							} else {
+								log.Println("Immediate collection was already enabled")
+							}
+						})
 						return nil
 					},
 				},
@@ -270,19 +940,20 @@ func main() {
 					Usage: "disable immediate data file collection",
 					Action: func(c *cli.Context) error {
 
-						clientId = strings.ToLower(clientId)
-						iaasClient := iaas.AwsClient{Region: region, ClientId: clientId}
-						controller := controller.Controller{Client: iaasClient}
+						controller := newController()
 
 						wasPreExisting, err := controller.DisableImmediateDataFileCollection()
 						if err != nil {
 							log.Fatalf("Error: %s\n", err.Error())
 						}
-						if wasPreExisting {
-							log.Println("Disabled immediate collection")
-						} else {
-							log.Println("Immediate collection was already disabled")
-						}
+						enabled := false
+						printResult(changeResult{Changed: wasPreExisting, Enabled: &enabled}, func() {
+							if wasPreExisting {
+								log.Println("Disabled immediate collection")
+							} else {
+								log.Println("Immediate collection was already disabled")
+							}
+						})
 						return nil
 					},
 				},
@@ -298,57 +969,523 @@ func main() {
 					Usage: "show the schedule status",
 					Action: func(c *cli.Context) error {
 
-						clientId = strings.ToLower(clientId)
-						iaasClient := iaas.AwsClient{Region: region, ClientId: clientId}
-						controller := controller.Controller{Client: iaasClient}
+						controller := newController()
 
-						if schedule, err := controller.GetSchedule(); err != nil {
+						schedule, err := controller.GetSchedule()
+						if err != nil {
 							log.Fatalf("Error: %s\n", err.Error())
-						} else {
-							log.Println("existing schedule: " + schedule)
 						}
+						printResult(scheduleResult(schedule), func() {
+							log.Println("existing schedule: " + schedule.String())
+							if schedule.IsSet() {
+								log.Println("Timezone: " + schedule.Timezone)
+								if schedule.Window.IsSet() {
+									log.Println("Arrival window: " + schedule.Window.String())
+								}
+								if schedule.FilePattern != "" {
+									log.Println("File pattern: " + schedule.FilePattern)
+								}
+								if schedule.GracePeriod != 0 {
+									log.Println("Grace period: " + schedule.GracePeriod.String())
+								}
+								if schedule.OwnerContact != "" {
+									log.Println("Owner contact: " + schedule.OwnerContact)
+								}
+								if schedule.Pause.IsSet() {
+									log.Println("Paused: " + schedule.Pause.String())
+								}
+							}
+						})
 						return nil
 					},
 				},
 				{
-					Name:  "daily",
-					Usage: "set a daily schedule",
+					Name:  "next",
+					Usage: "show the next expected arrivals in UTC",
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:        "count, n",
+							Usage:       "number of arrivals to show",
+							Value:       5,
+							Destination: &count,
+						},
+					},
 					Action: func(c *cli.Context) error {
 
-						clientId = strings.ToLower(clientId)
-						iaasClient := iaas.AwsClient{Region: region, ClientId: clientId}
-						controller := controller.Controller{Client: iaasClient}
+						ctrler := newController()
 
-						wasPreExisting, err := controller.SetSchedule("DAILY")
+						arrivals, err := ctrler.NextArrivals(time.Now(), count)
 						if err != nil {
 							log.Fatalf("Error: %s\n", err.Error())
 						}
-						if wasPreExisting {
-							log.Println("Set daily schedule")
-						} else {
-							log.Println("Daily schedule was already set")
+						if arrivals == nil {
+							arrivals = []controller.Arrival{}
 						}
+						printResult(arrivalsResult{Arrivals: arrivals}, func() {
+							for _, arrival := range arrivals {
+								if arrival.Deadline.Equal(arrival.End) {
+									log.Printf("%s to %s\n", arrival.Start.Format(time.RFC3339), arrival.End.Format(time.RFC3339))
+								} else {
+									log.Printf("%s to %s, missing after %s\n", arrival.Start.Format(time.RFC3339), arrival.End.Format(time.RFC3339), arrival.Deadline.Format(time.RFC3339))
+								}
+							}
+						})
 						return nil
 					},
 				},
 				{
-					Name:  "none",
-					Usage: "remove schedule",
+					Name:  "daily",
+					Usage: "set a daily schedule",
+					Flags: scheduleFlags,
 					Action: func(c *cli.Context) error {
-
-						clientId = strings.ToLower(clientId)
-						iaasClient := iaas.AwsClient{Region: region, ClientId: clientId}
-						controller := controller.Controller{Client: iaasClient}
-
-						wasPreExisting, err := controller.RemoveSchedule()
-						if err != nil {
-							log.Fatalf("Error: %s\n", err.Error())
-						}
-						if wasPreExisting {
-							log.Println("Removed schedule")
-						} else {
-							log.Println("No schedule existed to remove")
+						return setSchedule(controller.DailyInterval, "daily")
+					},
+				},
+				{
+					Name:  "weekly",
+					Usage: "set a weekly schedule",
+					Flags: append([]cli.Flag{
+						cli.StringFlag{
+							Name:        "day, d",
+							Usage:       "day of the week the files arrive, e.g. mon",
+							Destination: &day,
+						},
+					}, scheduleFlags...),
+					Action: func(c *cli.Context) error {
+						return setSchedule(controller.WeeklyInterval+" "+day, "weekly")
+					},
+				},
+				{
+					Name:  "monthly",
+					Usage: "set a monthly schedule",
+					Flags: append([]cli.Flag{
+						cli.StringFlag{
+							Name:        "day, d",
+							Usage:       "day of the month the files arrive, from 1 to 31, the last day of shorter months is used",
+							Destination: &day,
+						},
+					}, scheduleFlags...),
+					Action: func(c *cli.Context) error {
+						return setSchedule(controller.MonthlyInterval+" "+day, "monthly")
+					},
+				},
+				{
+					Name:      "cron",
+					Usage:     "set a schedule using a cron expression",
+					ArgsUsage: "\"minute hour day-of-month month day-of-week\"",
+					Flags:     scheduleFlags,
+					Action: func(c *cli.Context) error {
+						return setSchedule(controller.CronInterval+" "+strings.Join(c.Args(), " "), "cron")
+					},
+				},
+				{
+					Name:  "pause",
+					Usage: "stop expecting files until a given time, or until resumed, keeping the schedule",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:        "until, u",
+							Usage:       "local date, or date & time, that files are expected again, e.g. 2026-11-01",
+							Destination: &until,
+						},
+					},
+					Action: func(c *cli.Context) error {
+
+						ctrler := newController()
+
+						var untilTime time.Time
+						if until != "" {
+							schedule, err := ctrler.GetSchedule()
+							if err != nil {
+								log.Fatalf("Error: %s\n", err.Error())
+							}
+							if untilTime, err = schedule.ParseLocalTime(until); err != nil {
+								log.Fatalf("Error: %s\n", err.Error())
+							}
+						}
+						if err := ctrler.PauseSchedule(untilTime, time.Now()); err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+						result := changeResult{Changed: true}
+						if until != "" {
+							result.Until = &untilTime
+						}
+						printResult(result, func() {
+							if until == "" {
+								log.Println("Paused schedule until resumed")
+							} else {
+								log.Println("Paused schedule until " + untilTime.Format(time.RFC3339))
+							}
+						})
+						return nil
+					},
+				},
+				{
+					Name:  "resume",
+					Usage: "expect files again according to the schedule",
+					Action: func(c *cli.Context) error {
+
+						controller := newController()
+
+						wasPaused, err := controller.ResumeSchedule()
+						if err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+						printResult(changeResult{Changed: wasPaused}, func() {
+							if wasPaused {
+								log.Println("Resumed schedule")
+							} else {
+								log.Println("Schedule was not paused")
+							}
+						})
+						return nil
+					},
+				},
+				{
+					Name:  "none",
+					Usage: "remove schedule",
+					Action: func(c *cli.Context) error {
+
+						controller := newController()
+
+						wasPreExisting, err := controller.RemoveSchedule()
+						if err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+						printResult(changeResult{Changed: wasPreExisting}, func() {
+							if wasPreExisting {
+								log.Println("Removed schedule")
+							} else {
+								log.Println("No schedule existed to remove")
+							}
+						})
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:    "monitor",
+			Aliases: []string{"m"},
+			Usage:   "check that scheduled data files have arrived",
+			Subcommands: []cli.Command{
+				{
+					Name:  "check",
+					Usage: "report clients whose expected data file is overdue as JSON, exiting with 1 if any are overdue or 2 if any could not be checked",
+					Action: func(c *cli.Context) error {
+
+						ctrler := newController()
+
+						var reports []controller.ArrivalReport
+						var err error
+						if clientId != "" {
+							reports = []controller.ArrivalReport{ctrler.CheckClientArrival(strings.ToLower(clientId), time.Now())}
+						} else if reports, err = ctrler.CheckArrivals(time.Now()); err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+
+						exitCode := 0
+						for _, report := range reports {
+							switch report.Status {
+							case controller.ArrivalOverdue:
+								log.Printf("Client %s is overdue, expected by %s\n", report.ClientId, report.Expected.Deadline.Format(time.RFC3339))
+								exitCode = 1
+							case controller.ArrivalCheckFailed:
+								log.Printf("Unable to check client %s: %s\n", report.ClientId, report.Error)
+								if exitCode == 0 {
+									exitCode = 2
+								}
+							}
+						}
+
+						// the reports have always been written to stdout, so text output is JSON as well
+						if outputFormat == TextOutput {
+							outputFormat = JSONOutput
+						}
+						printResult(reports, nil)
+						os.Exit(exitCode)
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:    "retention",
+			Aliases: []string{"rt"},
+			Usage:   "manage how long the client's data files are kept",
+			Subcommands: []cli.Command{
+				{
+					Name:  "set",
+					Usage: "keep the files in a folder for a number of days, with 0 days keeping them indefinitely",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:        "folder",
+							Usage:       "folder whose files expire, input, processed or archive",
+							Destination: &folderName,
+						},
+						cli.IntFlag{
+							Name:        "days",
+							Usage:       "days after which the files are deleted, e.g. 30",
+							Destination: &days,
+						},
+					},
+					Action: func(c *cli.Context) error {
+
+						ctrler := newController()
+
+						if !c.IsSet("days") {
+							log.Fatalln("Error: You must specify the days to keep the files for")
+						}
+						folder, err := controller.ParseRetentionFolder(folderName)
+						if err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+						retention, err := ctrler.GetRetention()
+						if err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+						if err = retention.Set(folder, days); err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
 						}
+						if err = ctrler.SetRetention(retention); err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+						printResult(newRetentionResult(retention), func() {
+							log.Println("Retention set, run retention apply for it to take effect")
+						})
+
+						return nil
+					},
+				},
+				{
+					Name:  "show",
+					Usage: "show the retention rules",
+					Action: func(c *cli.Context) error {
+
+						ctrler := newController()
+
+						retention, err := ctrler.GetRetention()
+						if err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+						printResult(newRetentionResult(retention), func() {
+							if !retention.IsSet() {
+								log.Println("no retention rules")
+							}
+							for _, rule := range retention.Rules {
+								log.Println(rule)
+							}
+						})
+
+						return nil
+					},
+				},
+				{
+					Name:  "apply",
+					Usage: "apply the retention rules as lifecycle rules of the store, or by deleting the expired files where the store has no lifecycle support",
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:        "sweep",
+							Usage:       "delete the expired files now, rather than setting lifecycle rules",
+							Destination: &sweep,
+						},
+					},
+					Action: func(c *cli.Context) error {
+
+						ctrler := newController()
+
+						report, err := ctrler.ApplyRetention(sweep, time.Now())
+						printResult(filesResult{Files: newFileResults(report.Deleted), Lifecycle: report.Lifecycle}, func() {
+							if report.Lifecycle {
+								log.Println("Retention applied as lifecycle rules")
+								return
+							}
+							for _, file := range report.Deleted {
+								log.Printf("deleted %s\t%d\t%s\n", file.Name, file.Size, file.LastModified.UTC().Format(time.RFC3339))
+							}
+							log.Printf("deleted %d files\n", len(report.Deleted))
+						})
+						if err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "config",
+			Usage: "manage the config file of named profiles",
+			Subcommands: []cli.Command{
+				{
+					Name:  "init",
+					Usage: "save the settings given by flag as a profile, named by --profile or default",
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:        "force, f",
+							Usage:       "replace the profile if it already exists",
+							Destination: &force,
+						},
+					},
+					Action: func(c *cli.Context) error {
+
+						configFile, err := config.Load(configPath)
+						if err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+						name := configFile.ProfileName(profileName)
+						if _, found := configFile.Profiles[name]; found && !force {
+							log.Fatalf("Error: Profile %s already exists in %s, use --force to replace it\n", name, configPath)
+						}
+						profile := currentProfile()
+						if err = profile.Validate(); err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+						configFile.SetProfile(name, profile)
+						if err = configFile.Save(configPath); err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+
+						printResult(profileResult{Name: name, Path: configPath, Profile: profile}, func() {
+							log.Printf("saved profile %s to %s\n", name, configPath)
+						})
+						return nil
+					},
+				},
+				{
+					Name:  "show",
+					Usage: "show the settings in effect, from the profile, flags & SCHED_LOAD_* variables",
+					Action: func(c *cli.Context) error {
+
+						profile, err := applyProfile(c.GlobalIsSet)
+						if err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+						configFile, err := config.Load(configPath)
+						if err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+						name := configFile.ProfileName(profileName)
+
+						printResult(profileResult{Name: name, Path: configPath, Profile: profile}, func() {
+							log.Println("Config file: " + configPath)
+							log.Println("Profile: " + name)
+							for _, setting := range []struct{ name, value string }{
+								{"Backend", profile.Backend},
+								{"Region", profile.Region},
+								{"Endpoint", profile.Endpoint},
+								{"Root", profile.Root},
+								{"Integrator", profile.Integrator},
+								{"Client", profile.Client},
+								{"Credentials", profile.Credentials},
+								{"Credentials profile", profile.CredentialsProfile},
+								{"Proxy", profile.Proxy},
+								{"Server-side encryption", profile.SSE},
+								{"Server-side encryption KMS key", profile.SSEKMSKey},
+							} {
+								if setting.value != "" {
+									log.Println(setting.name + ": " + setting.value)
+								}
+							}
+						})
+						return nil
+					},
+				},
+				{
+					Name:  "validate",
+					Usage: "check the config file & every profile in it",
+					Action: func(c *cli.Context) error {
+
+						if _, err := os.Stat(configPath); err != nil {
+							log.Fatalf("Error: No config file at %s, create one with config init\n", configPath)
+						}
+						configFile, err := config.Load(configPath)
+						if err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+						if err = configFile.Validate(); err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+						if _, err = configFile.Profile(profileName); err != nil {
+							log.Fatalf("Error: %s in %s\n", err.Error(), configPath)
+						}
+
+						printResult(configResult{Path: configPath, Profiles: configFile.ProfileNames()}, func() {
+							log.Printf("%s is valid, with profiles: %s\n", configPath, strings.Join(configFile.ProfileNames(), ", "))
+						})
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "key",
+			Usage: "create keys for encrypting a client's data files client-side",
+			Subcommands: []cli.Command{
+				{
+					Name:  "create-pair",
+					Usage: "create an RSA key pair, whose public key is given to the client & private key kept by the integrator",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:        "public-key",
+							Usage:       "path to write the PEM public key to",
+							Destination: &publicKey,
+						},
+						cli.StringFlag{
+							Name:        "private-key",
+							Usage:       "path to write the PEM private key to",
+							Destination: &privateKey,
+						},
+					},
+					Action: func(c *cli.Context) error {
+
+						if publicKey == "" || privateKey == "" {
+							log.Fatalln("Error: You must specify paths for both the public & private keys")
+						}
+						publicPEM, privatePEM, err := iaas.GenerateRSAKeys(3072)
+						if err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+						if err = writeNewFile(privateKey, privatePEM, 0600); err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+						if err = writeNewFile(publicKey, publicPEM, 0644); err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+						printResult(keyResult{PublicKey: publicKey, PrivateKey: privateKey}, func() {
+							log.Printf("created key pair %s & %s\n", publicKey, privateKey)
+						})
+
+						return nil
+					},
+				},
+				{
+					Name:  "create-kms",
+					Usage: "create a master key in the local KMS",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:        "kms-dir",
+							Usage:       "directory of local KMS master keys",
+							Destination: &kmsDir,
+						},
+						cli.StringFlag{
+							Name:        "kms-key",
+							Usage:       "ID of the master key, e.g. the client's name",
+							Destination: &kmsKey,
+						},
+					},
+					Action: func(c *cli.Context) error {
+
+						if kmsDir == "" {
+							log.Fatalln("Error: You must specify the local KMS directory")
+						}
+						if err := (iaas.LocalKMS{Dir: kmsDir, KeyId: kmsKey}).CreateKey(); err != nil {
+							log.Fatalf("Error: %s\n", err.Error())
+						}
+						printResult(keyResult{KMSDir: kmsDir, KMSKey: kmsKey}, func() {
+							log.Printf("created key %s in %s\n", kmsKey, kmsDir)
+						})
+
 						return nil
 					},
 				},
@@ -358,6 +1495,21 @@ func main() {
 
 	app.Flags = flags
 
+	app.Before = func(c *cli.Context) error {
+		if err := validateOutputFormat(outputFormat); err != nil {
+			log.Fatalf("Error: %s\n", err.Error())
+		}
+		// the config commands load the config file themselves, so that a broken one can be validated & replaced
+		switch c.Args().First() {
+		case "config", "help", "h":
+			return nil
+		}
+		if _, err := applyProfile(c.IsSet); err != nil {
+			log.Fatalf("Error: %s\n", err.Error())
+		}
+		return nil
+	}
+
 	app.CommandNotFound = func(c *cli.Context, command string) {
 		log.Printf("Invalid command '%s'\n\n", command)
 		cli.ShowAppHelp(c)
@@ -365,3 +1517,204 @@ func main() {
 	}
 	app.Run(os.Args)
 }
+
+func setSchedule(scheduleText string, description string) error {
+
+	schedule, err := controller.ParseSchedule(scheduleText)
+	if err != nil {
+		log.Fatalf("Error: %s\n", err.Error())
+	}
+	if window != "" {
+		if schedule.Window, err = controller.ParseArrivalWindow(window); err != nil {
+			log.Fatalf("Error: %s\n", err.Error())
+		}
+	}
+	schedule.Timezone = timezone
+	schedule.FilePattern = filePattern
+	schedule.GracePeriod = gracePeriod
+	schedule.OwnerContact = ownerContact
+
+	ctrler := newController()
+
+	wasPreExisting, err := ctrler.SetSchedule(schedule)
+	if err != nil {
+		log.Fatalf("Error: %s\n", err.Error())
+	}
+	printResult(changeResult{Changed: wasPreExisting, Schedule: schedule}, func() {
+		if wasPreExisting {
+			log.Printf("Set %s schedule\n", description)
+		} else {
+			log.Printf("%s schedule was already set\n", strings.Title(description))
+		}
+	})
+	return nil
+}
+
+// applyProfile takes the settings of the chosen profile that are not set by flag or SCHED_LOAD_* variable,
+// giving the profile of the settings in effect
+func applyProfile(isSet func(name string) bool) (profile config.Profile, err error) {
+	configFile, err := config.Load(configPath)
+	if err != nil {
+		return
+	}
+	if profile, err = configFile.Profile(profileName); err != nil {
+		err = errors.New(err.Error() + " in " + configPath)
+		return
+	}
+
+	for _, setting := range []struct {
+		flag    string
+		global  *string
+		profile *string
+	}{
+		{"backend", &backend, &profile.Backend},
+		{"region", &region, &profile.Region},
+		{"endpoint", &endpoint, &profile.Endpoint},
+		{"root", &rootDir, &profile.Root},
+		{"integrator", &integratorId, &profile.Integrator},
+		{"client", &clientId, &profile.Client},
+		{"credentials", &credentials, &profile.Credentials},
+		{"credentials-profile", &credsProfile, &profile.CredentialsProfile},
+		{"proxy", &proxy, &profile.Proxy},
+		{"sse", &sse, &profile.SSE},
+		{"sse-kms-key", &sseKMSKey, &profile.SSEKMSKey},
+	} {
+		if isSet(setting.flag) || *setting.profile == "" {
+			*setting.profile = *setting.global
+		} else {
+			*setting.global = *setting.profile
+		}
+	}
+	// a mode other than aws:kms given by flag has no use for the profile's KMS key
+	if isSet("sse") && !isSet("sse-kms-key") && sse != iaas.SSEKMS {
+		sseKMSKey, profile.SSEKMSKey = "", ""
+	}
+	err = profile.Validate()
+	return
+}
+
+// currentProfile gives a profile of the settings given by flag & SCHED_LOAD_* variable
+func currentProfile() config.Profile {
+	return config.Profile{
+		Backend:            backend,
+		Region:             region,
+		Endpoint:           endpoint,
+		Root:               rootDir,
+		Integrator:         integratorId,
+		Client:             clientId,
+		Credentials:        credentials,
+		CredentialsProfile: credsProfile,
+		Proxy:              proxy,
+		SSE:                sse,
+		SSEKMSKey:          sseKMSKey,
+	}
+}
+
+func newController() controller.Controller {
+	return controller.Controller{Client: newIaaSClient()}
+}
+
+func newIaaSClient() iaas.IaaSClient {
+	clientId = strings.ToLower(clientId)
+	integratorId = strings.ToLower(integratorId)
+
+	if err := retryPolicy.Validate(); err != nil {
+		log.Fatalf("Error: %s\n", err)
+	}
+
+	var client iaas.IaaSClient
+	switch backend {
+	case "aws":
+		client = iaas.AwsClient{
+			Region:             region,
+			ClientId:           clientId,
+			IntegratorId:       integratorId,
+			Endpoint:           endpoint,
+			PathStyle:          pathStyle,
+			InsecureSkipVerify: insecure,
+			CABundle:           caBundle,
+			PartSize:           partSize * 1024 * 1024,
+			Concurrency:        concurrency,
+			StateDir:           stateDir,
+			Compression:        compression,
+			Encryption:         newKeyWrapper(),
+
+			ServerSideEncryption: sse,
+			KMSKeyId:             sseKMSKey,
+			OnConflict:           onConflict,
+
+			Credentials:        credentials,
+			CredentialsProfile: credsProfile,
+			Proxy:              proxy,
+			DisableSDKRetries:  true,
+		}
+	case "filesystem":
+		if rootDir == "" {
+			log.Fatalln("Error: You must specify a root directory for the filesystem backend")
+		}
+		client = iaas.FilesystemClient{
+			Root:         rootDir,
+			IntegratorId: integratorId,
+			ClientId:     clientId,
+			Compression:  compression,
+			Encryption:   newKeyWrapper(),
+			OnConflict:   onConflict,
+		}
+	default:
+		log.Fatalf("Error: Unknown backend %s\n", backend)
+	}
+	return iaas.RetryingClient{Client: client, Policy: retryPolicy}
+}
+
+// writeNewFile writes a file that must not already exist, so that a key is never overwritten
+func writeNewFile(filePath string, contents []byte, perm os.FileMode) (err error) {
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return
+	}
+	if _, err = file.Write(contents); err != nil {
+		file.Close()
+		return
+	}
+	return file.Close()
+}
+
+// newOutbox gives the outbox of the client, as the same machine may upload for several clients
+func newOutbox() controller.Outbox {
+	return controller.Outbox{Dir: filepath.Join(outboxDir, strings.ToLower(integratorId), strings.ToLower(clientId))}
+}
+
+func defaultOutboxDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".sched-load", "outbox")
+}
+
+// defaultStateDir is ~/.sched-load/uploads, or empty when there is no home directory, making uploads not resumable
+func defaultStateDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".sched-load", "uploads")
+}
+
+// newKeyWrapper gives the keys for client-side encryption, if any are given
+func newKeyWrapper() iaas.KeyWrapper {
+	if kmsDir != "" {
+		if publicKey != "" || privateKey != "" {
+			log.Fatalln("Error: You must specify either RSA keys or a local KMS, not both")
+		}
+		return iaas.LocalKMS{Dir: kmsDir, KeyId: kmsKey}
+	}
+	if publicKey == "" && privateKey == "" {
+		return nil
+	}
+	keys, err := iaas.LoadRSAKeys(publicKey, privateKey)
+	if err != nil {
+		log.Fatalf("Error: %s\n", err.Error())
+	}
+	return keys
+}
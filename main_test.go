@@ -215,6 +215,31 @@ var _ = Describe("SchedLoad", func() {
 			})
 		})
 
+		Context("When run with status argument & JSON output", func() {
+			BeforeEach(func() {
+				args = []string{"--region", region, "--client", clientName, "--output", "json", "status"}
+			})
+
+			It("writes the status to stdout", func() {
+				Ω(session.Out).Should(Say(`"credential_type": "integrator"`))
+				Ω(session.Out).Should(Say(`"client_id": "` + clientName + `"`))
+				Ω(session.Out).Should(Say(`"integrator_id": "` + integratorName + `"`))
+				Ω(session.Out).Should(Say(`"account_id": "` + accountId + `"`))
+				Ω(session.Err).ShouldNot(Say("Credential Type"))
+			})
+		})
+
+		Context("When run with an unknown output format", func() {
+			BeforeEach(func() {
+				args = []string{"--region", region, "--output", "xml", "status"}
+				expectedExitCode = 1
+			})
+
+			It("exits with error", func() {
+				Ω(session.Err).Should(Say(dateFormatRegex + " Error: Unknown output format xml, expected json, yaml or text"))
+			})
+		})
+
 		Context("When managing data files", func() {
 			Context("When uploading", func() {
 				BeforeEach(func() {
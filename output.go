@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/dhrapson/sched-load/config"
+	"github.com/dhrapson/sched-load/controller"
+	"github.com/dhrapson/sched-load/iaas"
+	"gopkg.in/yaml.v2"
+)
+
+// Formats of the --output flag. Text is logged to stderr for people, whereas JSON & YAML are written to stdout
+// for scripts, leaving stderr to the logs.
+const (
+	TextOutput = "text"
+	JSONOutput = "json"
+	YAMLOutput = "yaml"
+)
+
+func validateOutputFormat(format string) error {
+	switch format {
+	case TextOutput, JSONOutput, YAMLOutput:
+		return nil
+	}
+	return errors.New("Unknown output format " + format + ", expected json, yaml or text")
+}
+
+// printResult writes a command's result to stdout as JSON or YAML, or else calls text to log it as usual
+func printResult(result interface{}, text func()) {
+	if outputFormat == TextOutput {
+		text()
+		return
+	}
+	if err := writeResult(result); err != nil {
+		log.Fatalf("Error: %s\n", err.Error())
+	}
+}
+
+func writeResult(result interface{}) (err error) {
+	contents, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return
+	}
+	// YAML is converted from the JSON, as JSON is valid YAML, so that both use the same field names in the same order
+	if outputFormat == YAMLOutput {
+		var document interface{} = &yaml.MapSlice{}
+		if bytes.HasPrefix(contents, []byte("[")) {
+			document = &[]yaml.MapSlice{}
+		}
+		if err = yaml.Unmarshal(contents, document); err != nil {
+			return
+		}
+		if contents, err = yaml.Marshal(document); err != nil {
+			return
+		}
+	} else {
+		contents = append(contents, '\n')
+	}
+	_, err = os.Stdout.Write(contents)
+	return
+}
+
+type statusResult struct {
+	CredentialType string `json:"credential_type"`
+	ClientId       string `json:"client_id"`
+	IntegratorId   string `json:"integrator_id"`
+	AccountId      string `json:"account_id"`
+	Encryption     string `json:"encryption"`
+}
+
+type clientResult struct {
+	ClientId    string            `json:"client_id"`
+	Created     bool              `json:"created,omitempty"`
+	Existed     bool              `json:"existed,omitempty"`
+	Credentials map[string]string `json:"credentials,omitempty"`
+}
+
+// fileResult describes a remote data file, along with what a command did with it
+type fileResult struct {
+	Name            string     `json:"name"`
+	Path            string     `json:"path,omitempty"`
+	Size            *int64     `json:"size,omitempty"`
+	LastModified    *time.Time `json:"last_modified,omitempty"`
+	StorageClass    string     `json:"storage_class,omitempty"`
+	ETag            string     `json:"etag,omitempty"`
+	Checksum        string     `json:"checksum,omitempty"`
+	Compression     string     `json:"compression,omitempty"`
+	EncryptionKeyId string     `json:"encryption_key_id,omitempty"`
+	Encryption      string     `json:"encryption,omitempty"`
+	LocalPath       string     `json:"local_path,omitempty"`
+	MovedTo         string     `json:"moved_to,omitempty"`
+	SpooledTo       string     `json:"spooled_to,omitempty"`
+	Skipped         bool       `json:"skipped,omitempty"`
+	Error           string     `json:"error,omitempty"`
+}
+
+type filesResult struct {
+	Files     []fileResult `json:"files"`
+	Truncated bool         `json:"truncated,omitempty"`
+	DryRun    bool         `json:"dry_run,omitempty"`
+	Lifecycle bool         `json:"lifecycle,omitempty"`
+}
+
+type changeResult struct {
+	Changed  bool        `json:"changed"`
+	Enabled  *bool       `json:"enabled,omitempty"`
+	Until    *time.Time  `json:"until,omitempty"`
+	Schedule interface{} `json:"schedule,omitempty"`
+}
+
+type collectionResult struct {
+	Enabled bool `json:"enabled"`
+}
+
+type profileResult struct {
+	Name    string         `json:"name"`
+	Path    string         `json:"path"`
+	Profile config.Profile `json:"profile"`
+}
+
+type configResult struct {
+	Path     string   `json:"path"`
+	Profiles []string `json:"profiles"`
+}
+
+type arrivalsResult struct {
+	Arrivals []controller.Arrival `json:"arrivals"`
+}
+
+type scheduleOutput struct {
+	Schedule *controller.Schedule `json:"schedule"`
+}
+
+type retentionResult struct {
+	Rules []controller.RetentionRule `json:"rules"`
+}
+
+type keyResult struct {
+	PublicKey  string `json:"public_key,omitempty"`
+	PrivateKey string `json:"private_key,omitempty"`
+	KMSDir     string `json:"kms_dir,omitempty"`
+	KMSKey     string `json:"kms_key,omitempty"`
+}
+
+// scheduleResult gives the schedule document, or no schedule when none is set
+func scheduleResult(schedule controller.Schedule) (result scheduleOutput) {
+	if schedule.IsSet() {
+		result.Schedule = &schedule
+	}
+	return
+}
+
+func newRetentionResult(retention controller.Retention) retentionResult {
+	if retention.Rules == nil {
+		return retentionResult{Rules: []controller.RetentionRule{}}
+	}
+	return retentionResult{Rules: retention.Rules}
+}
+
+func newFileResult(file iaas.FileInfo) fileResult {
+	result := fileResult{
+		Name:            file.Name,
+		StorageClass:    file.StorageClass,
+		ETag:            file.ETag,
+		Checksum:        file.Checksum,
+		Compression:     file.Compression,
+		EncryptionKeyId: file.EncryptionKeyId,
+	}
+	if !file.LastModified.IsZero() {
+		size, lastModified := file.Size, file.LastModified.UTC()
+		result.Size, result.LastModified = &size, &lastModified
+	}
+	return result
+}
+
+func newFileResults(files []iaas.FileInfo) []fileResult {
+	results := []fileResult{}
+	for _, file := range files {
+		results = append(results, newFileResult(file))
+	}
+	return results
+}
+
+func newNameResults(names []string) []fileResult {
+	results := []fileResult{}
+	for _, name := range names {
+		results = append(results, fileResult{Name: name})
+	}
+	return results
+}